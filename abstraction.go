@@ -3,6 +3,7 @@ package zzkv
 import (
 	"github.com/gogf/gf/encoding/gcompress"
 	"encoding/json"
+	"fmt"
 	"github.com/pkg/errors"
 
 )
@@ -11,8 +12,9 @@ var NoneError = errors.New("nil value")
 
 // 压缩器接口
 type Compression interface {
-	Compress([]byte) []byte
-	Decompress([]byte) []byte
+	Compress([]byte) ([]byte, error)
+	// 数据无法解压时返回的错误可用errors.Is(err, ErrCorrupt)判断
+	Decompress([]byte) ([]byte, error)
 }
 
 // 数据抽象层
@@ -35,21 +37,17 @@ func Deserialize(serializedBytes []byte, val interface{}) error {
 // 默认压缩器，采用Gzip压缩
 type DefaultCompression struct {}
 
-func (compress *DefaultCompression) Compress(originalVal []byte) []byte {
-	data, err := gcompress.Gzip(originalVal)
-	if err != nil {
-		panic(err)
-	}
-	return data
+func (compress *DefaultCompression) Compress(originalVal []byte) ([]byte, error) {
+	return gcompress.Gzip(originalVal)
 }
 
-func (compress *DefaultCompression) Decompress(compressedVal []byte) []byte {
+func (compress *DefaultCompression) Decompress(compressedVal []byte) ([]byte, error) {
 	data, err := gcompress.UnGzip(compressedVal)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%w: %s", ErrCorrupt, err)
 	}
 
-	return data
+	return data, nil
 }
 
 func NewDefaultCompression() *DefaultCompression {
@@ -1,52 +1,56 @@
 package bitcask
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
-	"time"
+	"path/filepath"
+	"strings"
 )
 
 const DefaultFileMode os.FileMode = 0666
 
-
 type Position struct {
-	FileName 	string		`json:"file_name"`
-	Pos 		uint64		`json:"position"`
+	FileName string `json:"file_name"`
+	Pos      uint64 `json:"position"`
+	Size     uint32 `json:"size"`
 }
 
 type IndexItem struct {
-	Key 		string		`json:"key"`
-	CreateTime 	int64		`json:"create_time"`
-	PosItem		Position	`json:"pos_item"`
+	Key        string   `json:"key"`
+	CreateTime int64    `json:"create_time"`
+	ExpireAt   int64    `json:"expire_at"` // 过期时间的UnixNano，0表示永不过期
+	PosItem    Position `json:"pos_item"`
+}
+
+// 记录在数据文件中占用的大小
+func (index *IndexItem) recordSize() int64 {
+	return recordSize(uint32(len(index.Key)), index.PosItem.getSize(), index.ExpireAt)
 }
 
-func (index *IndexItem) pack() string {
+// now时刻是否已过期
+func (index *IndexItem) isExpired(now int64) bool {
+	return isExpired(index.ExpireAt, now)
+}
+
+func (index *IndexItem) pack() (string, error) {
 	packedData, packErr := json.Marshal(index)
 	if packErr != nil {
-		panic(fmt.Sprintf("failed to pack index item. error:[%s]", packErr))
+		return "", fmt.Errorf("failed to pack index item. error:[%w]", packErr)
 	}
 
-	return string(packedData)
+	return string(packedData), nil
 }
 
-func (index *IndexItem) unpack(data string) {
-	if index == nil {
-		index = new(IndexItem)
-	}
-
+func (index *IndexItem) unpack(data string) error {
 	unpackErr := json.Unmarshal([]byte(data), index)
 	if unpackErr != nil {
-		panic(fmt.Sprintf("failed to unpack index item. error:[%s]", unpackErr))
+		return fmt.Errorf("failed to unpack index item. error:[%w]", unpackErr)
 	}
 
-	return
+	return nil
 }
 
-
 func (pos *Position) getFileName() string {
 	return pos.FileName
 }
@@ -55,92 +59,187 @@ func (pos *Position) getPosition() uint64 {
 	return pos.Pos
 }
 
-func (pos *Position) pack() string {
+func (pos *Position) getSize() uint32 {
+	return pos.Size
+}
+
+func (pos *Position) pack() (string, error) {
 	packedData, packErr := json.Marshal(pos)
 	if packErr != nil {
-		panic(fmt.Sprintf("failed to pack position. error:[%s]", packErr))
+		return "", fmt.Errorf("failed to pack position. error:[%w]", packErr)
 	}
 
-	return string(packedData)
+	return string(packedData), nil
 }
 
-func (pos *Position) unpack(data string) {
-	if pos == nil {
-		pos = new(Position)
-	}
-
+func (pos *Position) unpack(data string) error {
 	unpackErr := json.Unmarshal([]byte(data), pos)
 	if unpackErr != nil {
-		panic(fmt.Sprintf("failed to unpack position. error:[%s]", unpackErr))
+		return fmt.Errorf("failed to unpack position. error:[%w]", unpackErr)
 	}
 
-	return
+	return nil
 }
 
-
+// keydir，记录每个key最新值所在的文件、偏移、大小及时间
 type IndexManager map[string]IndexItem
 
-type Storager struct {}
+type Storager struct {
+	dir          string
+	maxFileSize  int64
+	activeFile   string
+	activeHandle *os.File
+	activeSize   int64
+	fileMode     os.FileMode
+}
 
-func (s *Storager) Read(pos Position) []byte {
-	if pos.getFileName() == "" || pos.getPosition() <= 0 {
-		return nil
+func NewStorager(dir string, activeFile string, maxFileSize int64, fileMode os.FileMode) *Storager {
+	return &Storager{dir: dir, activeFile: activeFile, maxFileSize: maxFileSize, fileMode: fileMode}
+}
+
+// 读取索引指向的记录并校验，返回其中的value
+func (s *Storager) Read(item IndexItem) ([]byte, error) {
+	key, pos := item.Key, item.PosItem
+	if pos.getFileName() == "" {
+		return nil, nil
 	}
 
-	fileHandle, openErr := os.OpenFile(pos.getFileName(), os.O_RDONLY, DefaultFileMode)
+	fileHandle, openErr := os.OpenFile(filepath.Join(s.dir, pos.getFileName()), os.O_RDONLY, DefaultFileMode)
 	if openErr != nil {
-		panic(fmt.Sprintf("Occur fatal error while opening data file. error[%s]", openErr))
+		return nil, openErr
 	}
 	defer fileHandle.Close()
 
-	//move pointer to pos relative to the origin of the file
-	_, seekErr := fileHandle.Seek(int64(pos.getPosition()), os.SEEK_SET)
-	if seekErr != nil {
-		return nil
+	// 按偏移和大小一次读出整条记录，无需先seek
+	buf := make([]byte, item.recordSize())
+	_, readErr := fileHandle.ReadAt(buf, int64(pos.getPosition()))
+	if readErr != nil {
+		return nil, readErr
 	}
 
-	result, readErr := ioutil.ReadAll(fileHandle)
-	if readErr != nil {
-		panic(fmt.Sprintf("Occur fatal error while read file. error[%s]", readErr))
+	_, recordKey, value, ok := decodeRecord(buf)
+	if !ok || recordKey != key {
+		return nil, &ChecksumError{FileName: pos.getFileName(), Offset: pos.getPosition()}
 	}
 
-	return result
+	return value, nil
+}
+
+// 追加一条记录，返回记录位置，Size为value大小，expireAt为0表示永不过期
+func (s *Storager) Write(key string, value []byte, timestamp int64, expireAt int64, syncFlag bool) (*Position, error) {
+	return s.append(encodeRecord(timestamp, key, value, expireAt), uint32(len(value)), syncFlag)
 }
 
-func (s *Storager) Write(data []byte, syncFlag bool) (*Position, error) {
-	curDataFileName := s.generateCurrentDataFileName()
-	fileHandle, openErr := os.OpenFile(curDataFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, DefaultFileMode)
+// 追加一条删除标记
+func (s *Storager) WriteTombstone(key string, timestamp int64, syncFlag bool) (*Position, error) {
+	return s.append(encodeTombstone(timestamp, key), tombstoneValueSize, syncFlag)
+}
+
+// 追加到活跃文件，超过大小上限时切换到下一个编号的文件
+func (s *Storager) append(data []byte, valueSize uint32, syncFlag bool) (*Position, error) {
+	openErr := s.openActiveFile()
 	if openErr != nil {
 		return nil, openErr
 	}
-	defer fileHandle.Close()
-
-	curPos, seekErr := fileHandle.Seek(0, os.SEEK_CUR)
-	if seekErr != nil {
-		return nil, seekErr
+	if s.activeSize > 0 && s.activeSize+int64(len(data)) > s.maxFileSize {
+		closeErr := s.Close()
+		if closeErr != nil {
+			return nil, closeErr
+		}
+		s.activeFile = nextDataFileName(s.activeFile)
+		openErr = s.openActiveFile()
+		if openErr != nil {
+			return nil, openErr
+		}
 	}
 
-	_, writeErr := io.Copy(io.Writer(fileHandle), bytes.NewReader(data))
+	curPos := s.activeSize
+	_, writeErr := s.activeHandle.Write(data)
 	if writeErr != nil {
+		// 截掉写了一半的记录，避免后续记录接在残缺数据之后
+		_ = s.activeHandle.Truncate(curPos)
 		return nil, writeErr
 	}
+	s.activeSize += int64(len(data))
 
 	if syncFlag {
-		syncErr := fileHandle.Sync()
+		syncErr := s.activeHandle.Sync()
 		if syncErr != nil {
 			return nil, syncErr
 		}
 	}
 
-	posItem := &Position{FileName:curDataFileName, Pos:uint64(curPos)}
+	posItem := &Position{FileName: s.activeFile, Pos: uint64(curPos), Size: valueSize}
 	return posItem, nil
 }
 
-func (s *Storager) generateCurrentDataFileName() string {
-	now := time.Now()
-	return fmt.Sprintf("bitcask_%d-%2d-%2d.zzkv", now.Year(), now.Month(), now.Day())
+// 打开活跃文件，写入期间一直保持打开
+func (s *Storager) openActiveFile() error {
+	if s.activeHandle != nil {
+		return nil
+	}
+
+	fileHandle, openErr := os.OpenFile(filepath.Join(s.dir, s.activeFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, s.fileMode)
+	if openErr != nil {
+		return openErr
+	}
+	info, statErr := fileHandle.Stat()
+	if statErr != nil {
+		_ = fileHandle.Close()
+		return statErr
+	}
+
+	s.activeHandle = fileHandle
+	s.activeSize = info.Size()
+	return nil
+}
+
+func (s *Storager) Sync() error {
+	if s.activeHandle == nil {
+		return nil
+	}
+	return s.activeHandle.Sync()
+}
+
+func (s *Storager) Close() error {
+	if s.activeHandle == nil {
+		return nil
+	}
+
+	syncErr := s.activeHandle.Sync()
+	closeErr := s.activeHandle.Close()
+	s.activeHandle = nil
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
 }
 
+// 数据文件按编号命名，编号越大越新
+func dataFileName(fileID uint32) string {
+	return fmt.Sprintf("bitcask_%09d.zzkv", fileID)
+}
 
+func parseDataFileID(fileName string) (uint32, bool) {
+	var fileID uint32
+	_, scanErr := fmt.Sscanf(fileName, "bitcask_%d.zzkv", &fileID)
+	if scanErr != nil || dataFileName(fileID) != fileName {
+		return 0, false
+	}
+	return fileID, true
+}
 
+// 是否为bitcask的数据文件或hint文件，包括旧版按日期命名的文件
+// 与其他存储共用数据目录时据此跳过bitcask的文件
+func IsDataFile(fileName string) bool {
+	if strings.HasSuffix(fileName, ".hint") {
+		fileName = strings.TrimSuffix(fileName, ".hint") + ".zzkv"
+	}
+	matched, _ := filepath.Match("bitcask_*.zzkv", fileName)
+	return matched
+}
 
+func nextDataFileName(fileName string) string {
+	fileID, _ := parseDataFileID(fileName)
+	return dataFileName(fileID + 1)
+}
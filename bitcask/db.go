@@ -0,0 +1,403 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultDirMode     os.FileMode = 0755
+	DefaultMaxFileSize int64       = 128 << 20
+)
+
+var (
+	ErrKeyNotFound     = errors.New("bitcask: key not found")
+	ErrClosed          = errors.New("bitcask: db is closed")
+	ErrMergeInProgress = errors.New("bitcask: merge in progress")
+	ErrValueTooLarge   = errors.New("bitcask: value too large")
+	ErrLocked          = errors.New("bitcask: data directory is locked by another process")
+	ErrReadOnly        = errors.New("bitcask: db is opened read-only")
+	ErrNeedMigration   = errors.New("bitcask: legacy data files must be migrated by a read-write open first")
+)
+
+// 打开选项
+type Options struct {
+	// 单个数据文件的大小上限，超过后切换到新文件
+	MaxFileSize int64
+	// 每次写入后是否立即同步到磁盘
+	SyncWrites bool
+	// 不可变文件中的死数据占全部数据的比例超过该值时自动合并，0表示不自动合并
+	MergeRatio float64
+	// 只读打开，加共享锁，多个只读进程可同时打开同一目录，但不能与读写进程共存
+	ReadOnly bool
+	// 新建数据文件的权限
+	FileMode os.FileMode
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		MaxFileSize: DefaultMaxFileSize,
+		SyncWrites:  true,
+		MergeRatio:  0.5,
+		FileMode:    DefaultFileMode,
+	}
+}
+
+// 单个数据文件的大小及其中存活记录的大小
+type dataFileStat struct {
+	size      int64
+	liveBytes int64
+}
+
+// bitcask存储引擎，keydir常驻内存，每次读取只需一次定位
+type DB struct {
+	dir            string
+	opts           Options
+	dirLock        *DirLock
+	storager       *Storager
+	keydir         IndexManager
+	files          map[string]*dataFileStat
+	activeFile     string
+	truncatedBytes int64
+	merging        bool
+	merges         int
+	reclaimedBytes int64
+	bgWG           sync.WaitGroup
+	fileMu         sync.Mutex // 串行化后台hint生成与合并对文件的改写
+	closed         bool
+	sync.RWMutex
+}
+
+// 运行统计
+type Stats struct {
+	Keys           int   // 存活key数量
+	DataFiles      int   // 数据文件数量
+	TotalBytes     int64 // 数据文件总大小
+	DeadBytes      int64 // 已被覆盖或删除的记录大小
+	TruncatedBytes int64 // 打开时截掉的残缺尾部字节数
+	Merges         int   // 已完成的合并次数
+	ReclaimedBytes int64 // 合并累计回收的字节数
+}
+
+// 打开(不存在则创建)数据目录，并从已有数据文件重建keydir
+func Open(dir string) (*DB, error) {
+	return OpenWithOptions(dir, DefaultOptions())
+}
+
+func OpenWithOptions(dir string, opts *Options) (*DB, error) {
+	options := *DefaultOptions()
+	if opts != nil {
+		options = *opts
+	}
+	if options.MaxFileSize <= 0 {
+		options.MaxFileSize = DefaultMaxFileSize
+	}
+	if options.FileMode == 0 {
+		options.FileMode = DefaultFileMode
+	}
+
+	if !options.ReadOnly {
+		mkdirErr := os.MkdirAll(dir, DefaultDirMode)
+		if mkdirErr != nil {
+			return nil, mkdirErr
+		}
+	}
+	dirLock, lockErr := LockDir(dir, options.ReadOnly)
+	if lockErr != nil {
+		return nil, lockErr
+	}
+
+	db, openErr := openLocked(dir, options)
+	if openErr != nil {
+		_ = dirLock.Unlock()
+		return nil, openErr
+	}
+	db.dirLock = dirLock
+	return db, nil
+}
+
+// 已持有目录锁后打开
+func openLocked(dir string, options Options) (*DB, error) {
+	migrateErr := migrateLegacyDataFiles(dir, options.ReadOnly)
+	if migrateErr != nil {
+		return nil, migrateErr
+	}
+
+	// 最新的文件没有hint说明尚未切换，继续追加，否则从下一个编号开始
+	fileNames, listErr := listDataFiles(dir)
+	if listErr != nil {
+		return nil, listErr
+	}
+	activeFile := dataFileName(1)
+	if len(fileNames) > 0 {
+		activeFile = fileNames[len(fileNames)-1]
+		if _, statErr := os.Stat(filepath.Join(dir, hintFileName(activeFile))); statErr == nil {
+			activeFile = nextDataFileName(activeFile)
+		}
+	}
+
+	db := &DB{
+		dir:        dir,
+		opts:       options,
+		storager:   NewStorager(dir, activeFile, options.MaxFileSize, options.FileMode),
+		keydir:     make(IndexManager),
+		files:      make(map[string]*dataFileStat),
+		activeFile: activeFile,
+	}
+
+	recoverErr := db.recover()
+	if recoverErr != nil {
+		db.bgWG.Wait()
+		return nil, recoverErr
+	}
+	return db, nil
+}
+
+func (db *DB) Put(key string, value []byte) error {
+	return db.PutWithExpiry(key, value, 0)
+}
+
+// 写入带过期时间的value，expireAt为过期时间的UnixNano，0表示永不过期
+// 过期后的key读不到，重启及合并时丢弃
+func (db *DB) PutWithExpiry(key string, value []byte, expireAt int64) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if uint64(len(value)) >= uint64(tombstoneValueSize) {
+		return ErrValueTooLarge
+	}
+
+	return db.putLocked(key, value, expireAt)
+}
+
+// 修改过期时间，expireAt为0表示去掉过期时间
+// 重新追加一条带新过期时间的记录，key不存在或已过期时返回ErrKeyNotFound
+func (db *DB) Expire(key string, expireAt int64) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	item, ok := db.keydir[key]
+	if !ok || item.isExpired(time.Now().UnixNano()) {
+		return ErrKeyNotFound
+	}
+	if item.ExpireAt == expireAt {
+		return nil
+	}
+	value, readErr := db.storager.Read(item)
+	if readErr != nil {
+		return readErr
+	}
+	return db.putLocked(key, value, expireAt)
+}
+
+func (db *DB) putLocked(key string, value []byte, expireAt int64) error {
+	now := time.Now().Unix()
+	pos, writeErr := db.storager.Write(key, value, now, expireAt, db.opts.SyncWrites)
+	if writeErr != nil {
+		return writeErr
+	}
+	item := IndexItem{Key: key, CreateTime: now, ExpireAt: expireAt, PosItem: *pos}
+	db.afterWrite(&item)
+
+	db.markDead(key)
+	db.keydir[key] = item
+	db.fileStat(pos.FileName).liveBytes += item.recordSize()
+
+	db.maybeMerge()
+	return nil
+}
+
+func (db *DB) Get(key string) ([]byte, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	item, ok := db.keydir[key]
+	if !ok || item.isExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+
+	return db.storager.Read(item)
+}
+
+func (db *DB) Delete(key string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if _, ok := db.keydir[key]; !ok {
+		return ErrKeyNotFound
+	}
+
+	// 追加删除标记，重启及合并后该key都不会再出现
+	pos, writeErr := db.storager.WriteTombstone(key, time.Now().Unix(), db.opts.SyncWrites)
+	if writeErr != nil {
+		return writeErr
+	}
+	db.afterWrite(&IndexItem{Key: key, PosItem: *pos})
+
+	db.markDead(key)
+	delete(db.keydir, key)
+
+	db.maybeMerge()
+	return nil
+}
+
+// 将活跃文件同步到磁盘
+func (db *DB) Sync() error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		return nil
+	}
+	return db.storager.Sync()
+}
+
+// 当前所有未过期的key，顺序不固定
+func (db *DB) Keys() ([]string, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, len(db.keydir))
+	for key, item := range db.keydir {
+		if !item.isExpired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// 所有设置了过期时间且尚未过期的key及其过期时间(UnixNano)
+func (db *DB) Expiries() (map[string]int64, error) {
+	db.RLock()
+	defer db.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	expiries := make(map[string]int64)
+	for key, item := range db.keydir {
+		if item.ExpireAt != 0 && !item.isExpired(now) {
+			expiries[key] = item.ExpireAt
+		}
+	}
+	return expiries, nil
+}
+
+func (db *DB) Stats() Stats {
+	db.RLock()
+	defer db.RUnlock()
+
+	stats := Stats{
+		Keys:           len(db.keydir),
+		DataFiles:      len(db.files),
+		TruncatedBytes: db.truncatedBytes,
+		Merges:         db.merges,
+		ReclaimedBytes: db.reclaimedBytes,
+	}
+	for _, fileStat := range db.files {
+		stats.TotalBytes += fileStat.size
+		stats.DeadBytes += fileStat.size - fileStat.liveBytes
+	}
+	return stats
+}
+
+// 记录追加后更新文件统计，活跃文件切换时为之前的文件生成hint
+func (db *DB) afterWrite(item *IndexItem) {
+	if item.PosItem.FileName != db.activeFile {
+		db.generateHint(db.activeFile)
+		db.activeFile = item.PosItem.FileName
+	}
+	db.fileStat(item.PosItem.FileName).size += item.recordSize()
+}
+
+func (db *DB) fileStat(fileName string) *dataFileStat {
+	fileStat, ok := db.files[fileName]
+	if !ok {
+		fileStat = &dataFileStat{}
+		db.files[fileName] = fileStat
+	}
+	return fileStat
+}
+
+// key的旧记录变为死数据
+func (db *DB) markDead(key string) {
+	if old, ok := db.keydir[key]; ok {
+		db.fileStat(old.PosItem.FileName).liveBytes -= old.recordSize()
+	}
+}
+
+// 后台生成hint，失败只影响下次启动速度，不影响正确性
+func (db *DB) generateHint(dataFileName string) {
+	if _, statErr := os.Stat(filepath.Join(db.dir, dataFileName)); statErr != nil {
+		return
+	}
+
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		db.fileMu.Lock()
+		defer db.fileMu.Unlock()
+		_ = writeHintFile(db.dir, dataFileName, db.opts.FileMode)
+	}()
+}
+
+func (db *DB) Close() error {
+	db.Lock()
+	if db.closed {
+		db.Unlock()
+		return ErrClosed
+	}
+	db.closed = true
+	db.Unlock()
+
+	// 等待后台的hint生成及合并结束
+	db.bgWG.Wait()
+
+	db.Lock()
+	defer db.Unlock()
+	db.keydir = nil
+	closeErr := db.storager.Close()
+	unlockErr := db.dirLock.Unlock()
+	if closeErr != nil {
+		return closeErr
+	}
+	return unlockErr
+}
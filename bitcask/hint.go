@@ -0,0 +1,144 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// hint文件记录不可变数据文件中每个key最后一条记录的位置，启动时代替全量扫描
+// 条目格式:
+// | timestamp(8) | keySize(4) | valueSize(4) | offset(8) | [expireAt(8)] | key |
+// 文件末尾为全部条目的crc32(4)
+// 与数据记录相同，keySize最高位为1时带过期时间
+const hintEntryHeaderSize = 24
+
+type hintEntry struct {
+	timestamp int64
+	key       string
+	valueSize uint32
+	offset    int64
+	expireAt  int64
+}
+
+func hintFileName(dataFileName string) string {
+	return strings.TrimSuffix(dataFileName, ".zzkv") + ".hint"
+}
+
+// 扫描数据文件生成hint文件，先写临时文件再改名，保证hint要么完整要么不存在
+func writeHintFile(dir string, dataFileName string, fileMode os.FileMode) error {
+	entries := make(map[string]hintEntry)
+	_, scanErr := scanDataFile(filepath.Join(dir, dataFileName), func(header recordHeader, key string, offset int64) {
+		entries[key] = hintEntry{timestamp: header.timestamp, key: key, valueSize: header.valueSize, offset: offset, expireAt: header.expireAt}
+	})
+	if scanErr != nil {
+		return scanErr
+	}
+
+	sorted := make([]hintEntry, 0, len(entries))
+	for _, entry := range entries {
+		sorted = append(sorted, entry)
+	}
+	return writeHint(dir, dataFileName, sorted, fileMode)
+}
+
+// 按偏移排序后写入hint文件，与数据文件中的顺序一致
+func writeHint(dir string, dataFileName string, sorted []hintEntry, fileMode os.FileMode) error {
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].offset < sorted[j].offset
+	})
+
+	buf := make([]byte, 0)
+	entryHeader := make([]byte, hintEntryHeaderSize)
+	expiryBuf := make([]byte, expiryFieldSize)
+	for _, entry := range sorted {
+		keySize := uint32(len(entry.key))
+		if entry.expireAt != 0 {
+			keySize |= expiryFlag
+		}
+		binary.BigEndian.PutUint64(entryHeader[0:8], uint64(entry.timestamp))
+		binary.BigEndian.PutUint32(entryHeader[8:12], keySize)
+		binary.BigEndian.PutUint32(entryHeader[12:16], entry.valueSize)
+		binary.BigEndian.PutUint64(entryHeader[16:24], uint64(entry.offset))
+		buf = append(buf, entryHeader...)
+		if entry.expireAt != 0 {
+			binary.BigEndian.PutUint64(expiryBuf, uint64(entry.expireAt))
+			buf = append(buf, expiryBuf...)
+		}
+		buf = append(buf, entry.key...)
+	}
+	crcBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(crcBuf, crc32.ChecksumIEEE(buf))
+	buf = append(buf, crcBuf...)
+
+	return writeFileAtomic(filepath.Join(dir, hintFileName(dataFileName)), buf, fileMode)
+}
+
+// 读取hint文件，文件不存在或已损坏时返回false
+func readHintFile(dir string, dataFileName string) ([]hintEntry, bool) {
+	buf, readErr := ioutil.ReadFile(filepath.Join(dir, hintFileName(dataFileName)))
+	if readErr != nil || len(buf) < 4 {
+		return nil, false
+	}
+
+	body := buf[:len(buf)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, false
+	}
+
+	entries := make([]hintEntry, 0)
+	for len(body) > 0 {
+		if len(body) < hintEntryHeaderSize {
+			return nil, false
+		}
+		keySize := binary.BigEndian.Uint32(body[8:12])
+		keyOffset := hintEntryHeaderSize
+		if keySize&expiryFlag != 0 {
+			keyOffset += expiryFieldSize
+		}
+		keyEnd := keyOffset + int(keySize&^expiryFlag)
+		if len(body) < keyEnd {
+			return nil, false
+		}
+		entry := hintEntry{
+			timestamp: int64(binary.BigEndian.Uint64(body[0:8])),
+			valueSize: binary.BigEndian.Uint32(body[12:16]),
+			offset:    int64(binary.BigEndian.Uint64(body[16:24])),
+			key:       string(body[keyOffset:keyEnd]),
+		}
+		if keyOffset > hintEntryHeaderSize {
+			entry.expireAt = int64(binary.BigEndian.Uint64(body[hintEntryHeaderSize:keyOffset]))
+		}
+		entries = append(entries, entry)
+		body = body[keyEnd:]
+	}
+
+	return entries, true
+}
+
+func writeFileAtomic(path string, data []byte, fileMode os.FileMode) error {
+	tmpPath := path + ".tmp"
+	fileHandle, openErr := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if openErr != nil {
+		return openErr
+	}
+
+	_, writeErr := fileHandle.Write(data)
+	if writeErr == nil {
+		writeErr = fileHandle.Sync()
+	}
+	closeErr := fileHandle.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		_ = os.Remove(tmpPath)
+		return writeErr
+	}
+
+	return os.Rename(tmpPath, path)
+}
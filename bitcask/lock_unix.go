@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const LockFileName = "LOCK"
+
+// 数据目录锁，基于flock，进程退出后由内核自动释放
+type DirLock struct {
+	fileHandle *os.File
+}
+
+// 锁定数据目录，shared为true时加共享锁，多个只读进程可同时持有
+// 已被其他进程锁定时立即返回ErrLocked，不阻塞等待
+func LockDir(dir string, shared bool) (*DirLock, error) {
+	fileHandle, openErr := os.OpenFile(filepath.Join(dir, LockFileName), os.O_RDONLY|os.O_CREATE, DefaultFileMode)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	lockErr := syscall.Flock(int(fileHandle.Fd()), how|syscall.LOCK_NB)
+	if lockErr != nil {
+		_ = fileHandle.Close()
+		if lockErr == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, lockErr
+	}
+
+	return &DirLock{fileHandle: fileHandle}, nil
+}
+
+func (l *DirLock) Unlock() error {
+	unlockErr := syscall.Flock(int(l.fileHandle.Fd()), syscall.LOCK_UN)
+	closeErr := l.fileHandle.Close()
+	if unlockErr != nil {
+		return unlockErr
+	}
+	return closeErr
+}
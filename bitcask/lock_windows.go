@@ -0,0 +1,62 @@
+package bitcask
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const LockFileName = "LOCK"
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// 数据目录锁，基于LockFileEx锁定锁文件的第一个字节，进程退出后由系统自动释放
+type DirLock struct {
+	fileHandle *os.File
+}
+
+// 锁定数据目录，shared为true时加共享锁，多个只读进程可同时持有
+// 已被其他进程锁定时立即返回ErrLocked，不阻塞等待
+func LockDir(dir string, shared bool) (*DirLock, error) {
+	fileHandle, openErr := os.OpenFile(filepath.Join(dir, LockFileName), os.O_RDONLY|os.O_CREATE, DefaultFileMode)
+	if openErr != nil {
+		return nil, openErr
+	}
+
+	flags := uintptr(lockfileFailImmediately)
+	if !shared {
+		flags |= lockfileExclusiveLock
+	}
+	var overlapped syscall.Overlapped
+	result, _, lockErr := procLockFileEx.Call(fileHandle.Fd(), flags, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if result == 0 {
+		_ = fileHandle.Close()
+		if lockErr == errorLockViolation {
+			return nil, ErrLocked
+		}
+		return nil, lockErr
+	}
+
+	return &DirLock{fileHandle: fileHandle}, nil
+}
+
+func (l *DirLock) Unlock() error {
+	var overlapped syscall.Overlapped
+	result, _, unlockErr := procUnlockFileEx.Call(l.fileHandle.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	closeErr := l.fileHandle.Close()
+	if result == 0 {
+		return unlockErr
+	}
+	return closeErr
+}
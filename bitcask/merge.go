@@ -0,0 +1,309 @@
+package bitcask
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 合并中被复制的一条存活记录
+type mergeItem struct {
+	item   IndexItem
+	newPos Position
+}
+
+// 将所有不可变文件中的存活记录重写到新文件，生成hint并删除旧文件
+// 复制过程不持有写锁，只在替换文件和更新keydir时短暂加锁，读请求看到的要么全是旧文件要么全是新文件
+func (db *DB) Merge() error {
+	db.Lock()
+	if db.closed {
+		db.Unlock()
+		return ErrClosed
+	}
+	if db.opts.ReadOnly {
+		db.Unlock()
+		return ErrReadOnly
+	}
+	if db.merging {
+		db.Unlock()
+		return ErrMergeInProgress
+	}
+	db.merging = true
+	// 与后台合并一样计入bgWG，Close等合并结束后才释放目录锁
+	db.bgWG.Add(1)
+	db.Unlock()
+	defer db.bgWG.Done()
+
+	return db.merge()
+}
+
+func (db *DB) merge() error {
+	defer func() {
+		db.Lock()
+		db.merging = false
+		db.Unlock()
+	}()
+
+	db.Lock()
+	mergeFiles := make([]string, 0)
+	for fileName := range db.files {
+		if fileName != db.activeFile {
+			mergeFiles = append(mergeFiles, fileName)
+		}
+	}
+	sort.Strings(mergeFiles)
+	fileOrder := make(map[string]int)
+	for i, fileName := range mergeFiles {
+		fileOrder[fileName] = i
+	}
+
+	// 已过期的记录不再复制
+	now := time.Now().UnixNano()
+	items := make([]*mergeItem, 0)
+	expired := make([]IndexItem, 0)
+	for _, item := range db.keydir {
+		if _, ok := fileOrder[item.PosItem.FileName]; !ok {
+			continue
+		}
+		if item.isExpired(now) {
+			expired = append(expired, item)
+			continue
+		}
+		items = append(items, &mergeItem{item: item})
+	}
+	db.Unlock()
+
+	if len(mergeFiles) == 0 {
+		return nil
+	}
+	db.fileMu.Lock()
+	defer db.fileMu.Unlock()
+
+	// 按原文件顺序及偏移排序，顺序读取旧文件
+	sort.Slice(items, func(i, j int) bool {
+		iPos, jPos := items[i].item.PosItem, items[j].item.PosItem
+		if iPos.FileName != jPos.FileName {
+			return fileOrder[iPos.FileName] < fileOrder[jPos.FileName]
+		}
+		return iPos.Pos < jPos.Pos
+	})
+
+	outputs, writeErr := db.writeMergeFiles(mergeFiles, items)
+	if writeErr != nil {
+		removeMergeOutputs(outputs)
+		return writeErr
+	}
+
+	commitErr := db.commitMerge(mergeFiles, outputs, items, expired)
+	if commitErr != nil {
+		removeMergeOutputs(outputs)
+		return commitErr
+	}
+
+	for _, output := range outputs {
+		_ = writeHint(db.dir, output.fileName, output.hintEntries, db.opts.FileMode)
+	}
+	return nil
+}
+
+// 一个合并结果文件
+type mergeOutput struct {
+	fileName    string // 替换后使用的文件名
+	tmpPath     string
+	size        int64
+	hintEntries []hintEntry
+}
+
+// 将存活记录原样复制到临时文件，超过大小上限时切换到下一个结果文件
+// 第k个结果文件沿用第k旧的被合并文件的名字，保证合并结果的回放顺序仍早于其余文件
+// 被合并文件的名字用完后，剩余记录全部写入最后一个结果文件
+func (db *DB) writeMergeFiles(mergeFiles []string, items []*mergeItem) ([]*mergeOutput, error) {
+	outputs := make([]*mergeOutput, 0)
+	var output *os.File
+	var writer *bufio.Writer
+	finishOutput := func() error {
+		if output == nil {
+			return nil
+		}
+		flushErr := writer.Flush()
+		if flushErr == nil {
+			flushErr = output.Sync()
+		}
+		closeErr := output.Close()
+		output = nil
+		if flushErr != nil {
+			return flushErr
+		}
+		return closeErr
+	}
+	defer finishOutput()
+
+	inputs := make(map[string]*os.File)
+	defer func() {
+		for _, input := range inputs {
+			_ = input.Close()
+		}
+	}()
+
+	for _, mergeItem := range items {
+		pos := mergeItem.item.PosItem
+		input, ok := inputs[pos.FileName]
+		if !ok {
+			var inputErr error
+			input, inputErr = os.Open(filepath.Join(db.dir, pos.FileName))
+			if inputErr != nil {
+				return outputs, inputErr
+			}
+			inputs[pos.FileName] = input
+		}
+
+		buf := make([]byte, mergeItem.item.recordSize())
+		_, readErr := input.ReadAt(buf, int64(pos.Pos))
+		if readErr != nil {
+			return outputs, readErr
+		}
+		if _, key, _, ok := decodeRecord(buf); !ok || key != mergeItem.item.Key {
+			return outputs, &ChecksumError{FileName: pos.FileName, Offset: pos.Pos}
+		}
+
+		current := len(outputs) - 1
+		if current < 0 || (outputs[current].size+int64(len(buf)) > db.opts.MaxFileSize && current+1 < len(mergeFiles)) {
+			finishErr := finishOutput()
+			if finishErr != nil {
+				return outputs, finishErr
+			}
+
+			fileName := mergeFiles[len(outputs)]
+			tmpPath := filepath.Join(db.dir, fileName) + ".merge"
+			var openErr error
+			output, openErr = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, db.opts.FileMode)
+			if openErr != nil {
+				return outputs, openErr
+			}
+			writer = bufio.NewWriterSize(output, 1<<20)
+			outputs = append(outputs, &mergeOutput{fileName: fileName, tmpPath: tmpPath})
+			current = len(outputs) - 1
+		}
+
+		_, writeErr := writer.Write(buf)
+		if writeErr != nil {
+			return outputs, writeErr
+		}
+		mergeItem.newPos = Position{FileName: outputs[current].fileName, Pos: uint64(outputs[current].size), Size: pos.Size}
+		outputs[current].hintEntries = append(outputs[current].hintEntries, hintEntry{
+			timestamp: mergeItem.item.CreateTime,
+			key:       mergeItem.item.Key,
+			valueSize: pos.Size,
+			offset:    outputs[current].size,
+			expireAt:  mergeItem.item.ExpireAt,
+		})
+		outputs[current].size += int64(len(buf))
+	}
+
+	return outputs, finishOutput()
+}
+
+func removeMergeOutputs(outputs []*mergeOutput) {
+	for _, output := range outputs {
+		_ = os.Remove(output.tmpPath)
+	}
+}
+
+// 加锁替换文件并更新keydir
+// 按从旧到新的顺序逐个替换，中途崩溃时已替换的文件只含最新的存活记录，未替换的旧文件回放在其后，结果不变
+func (db *DB) commitMerge(mergeFiles []string, outputs []*mergeOutput, items []*mergeItem, expired []IndexItem) error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+
+	var mergedSize, outputSize int64
+	for _, fileName := range mergeFiles {
+		mergedSize += db.files[fileName].size
+	}
+
+	// 先删除旧hint再替换数据文件，中途崩溃最多丢失hint
+	for _, output := range outputs {
+		_ = os.Remove(filepath.Join(db.dir, hintFileName(output.fileName)))
+		renameErr := os.Rename(output.tmpPath, filepath.Join(db.dir, output.fileName))
+		if renameErr != nil {
+			return renameErr
+		}
+	}
+	for _, fileName := range mergeFiles[len(outputs):] {
+		_ = os.Remove(filepath.Join(db.dir, hintFileName(fileName)))
+		_ = os.Remove(filepath.Join(db.dir, fileName))
+	}
+	syncErr := syncDir(db.dir)
+	if syncErr != nil {
+		return syncErr
+	}
+
+	for _, fileName := range mergeFiles {
+		delete(db.files, fileName)
+	}
+	for _, output := range outputs {
+		db.files[output.fileName] = &dataFileStat{size: output.size}
+		outputSize += output.size
+	}
+
+	// 复制期间被覆盖或删除的key保持现状，其余指向新位置
+	for _, mergeItem := range items {
+		cur, ok := db.keydir[mergeItem.item.Key]
+		if !ok || cur.PosItem != mergeItem.item.PosItem {
+			continue
+		}
+		cur.PosItem = mergeItem.newPos
+		db.keydir[mergeItem.item.Key] = cur
+		db.files[cur.PosItem.FileName].liveBytes += cur.recordSize()
+	}
+	// 未被复制的过期key随旧文件一起消失
+	for _, item := range expired {
+		if cur, ok := db.keydir[item.Key]; ok && cur.PosItem == item.PosItem {
+			delete(db.keydir, item.Key)
+		}
+	}
+
+	db.merges++
+	db.reclaimedBytes += mergedSize - outputSize
+	return nil
+}
+
+// 死数据比例超过阈值时在后台自动合并，调用方需持有写锁
+func (db *DB) maybeMerge() {
+	if db.opts.MergeRatio <= 0 || db.merging {
+		return
+	}
+
+	var totalBytes, deadBytes int64
+	for fileName, fileStat := range db.files {
+		totalBytes += fileStat.size
+		if fileName != db.activeFile {
+			deadBytes += fileStat.size - fileStat.liveBytes
+		}
+	}
+	if totalBytes == 0 || float64(deadBytes)/float64(totalBytes) < db.opts.MergeRatio {
+		return
+	}
+
+	db.merging = true
+	db.bgWG.Add(1)
+	go func() {
+		defer db.bgWG.Done()
+		_ = db.merge()
+	}()
+}
+
+func syncDir(dir string) error {
+	dirHandle, openErr := os.Open(dir)
+	if openErr != nil {
+		return openErr
+	}
+	defer dirHandle.Close()
+
+	return dirHandle.Sync()
+}
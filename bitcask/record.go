@@ -0,0 +1,158 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// 记录格式:
+// | crc32(4) | timestamp(8) | keySize(4) | valueSize(4) | [expireAt(8)] | key | value |
+// crc覆盖crc字段之后的全部内容
+// keySize最高位为1时头部之后紧跟过期时间(UnixNano)，不带过期时间的记录与旧格式相同
+const RecordHeaderSize = 20
+
+// valueSize为该值的记录是删除标记(tombstone)，不带value
+const tombstoneValueSize uint32 = math.MaxUint32
+
+const (
+	expiryFlag      uint32 = 1 << 31
+	expiryFieldSize        = 8
+)
+
+// 记录校验失败
+type ChecksumError struct {
+	FileName string
+	Offset   uint64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("bitcask: checksum mismatch. file[%s] offset[%d]", e.FileName, e.Offset)
+}
+
+type recordHeader struct {
+	crc       uint32
+	timestamp int64
+	keySize   uint32
+	valueSize uint32
+	hasExpiry bool
+	expireAt  int64 // 过期时间的UnixNano，0表示永不过期
+}
+
+func (h *recordHeader) isTombstone() bool {
+	return h.valueSize == tombstoneValueSize
+}
+
+// now时刻是否已过期
+func (h *recordHeader) isExpired(now int64) bool {
+	return isExpired(h.expireAt, now)
+}
+
+func isExpired(expireAt int64, now int64) bool {
+	return expireAt != 0 && now >= expireAt
+}
+
+// 整条记录的长度
+func (h *recordHeader) size() int64 {
+	result := recordSize(h.keySize, h.valueSize, 0)
+	if h.hasExpiry {
+		result += expiryFieldSize
+	}
+	return result
+}
+
+// 头部之后、key之前的扩展字段长度
+func (h *recordHeader) extSize() int {
+	if h.hasExpiry {
+		return expiryFieldSize
+	}
+	return 0
+}
+
+func (h *recordHeader) encode(buf []byte) {
+	keySize := h.keySize
+	if h.hasExpiry {
+		keySize |= expiryFlag
+		binary.BigEndian.PutUint64(buf[RecordHeaderSize:RecordHeaderSize+expiryFieldSize], uint64(h.expireAt))
+	}
+	binary.BigEndian.PutUint32(buf[0:4], h.crc)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(h.timestamp))
+	binary.BigEndian.PutUint32(buf[12:16], keySize)
+	binary.BigEndian.PutUint32(buf[16:20], h.valueSize)
+}
+
+// buf至少包含固定头部，带过期时间时buf足够长才解析过期时间
+func (h *recordHeader) decode(buf []byte) {
+	h.crc = binary.BigEndian.Uint32(buf[0:4])
+	h.timestamp = int64(binary.BigEndian.Uint64(buf[4:12]))
+	keySize := binary.BigEndian.Uint32(buf[12:16])
+	h.keySize = keySize &^ expiryFlag
+	h.hasExpiry = keySize&expiryFlag != 0
+	h.valueSize = binary.BigEndian.Uint32(buf[16:20])
+	if h.hasExpiry && len(buf) >= RecordHeaderSize+expiryFieldSize {
+		h.decodeExpiry(buf[RecordHeaderSize:])
+	}
+}
+
+func (h *recordHeader) decodeExpiry(buf []byte) {
+	h.expireAt = int64(binary.BigEndian.Uint64(buf[0:expiryFieldSize]))
+}
+
+// 记录总长度，expireAt为0表示不带过期时间
+func recordSize(keySize uint32, valueSize uint32, expireAt int64) int64 {
+	if valueSize == tombstoneValueSize {
+		valueSize = 0
+	}
+	result := RecordHeaderSize + int64(keySize) + int64(valueSize)
+	if expireAt != 0 {
+		result += expiryFieldSize
+	}
+	return result
+}
+
+func encodeRecord(timestamp int64, key string, value []byte, expireAt int64) []byte {
+	return encode(timestamp, key, value, uint32(len(value)), expireAt)
+}
+
+func encodeTombstone(timestamp int64, key string) []byte {
+	return encode(timestamp, key, nil, tombstoneValueSize, 0)
+}
+
+func encode(timestamp int64, key string, value []byte, valueSize uint32, expireAt int64) []byte {
+	buf := make([]byte, recordSize(uint32(len(key)), valueSize, expireAt))
+	header := recordHeader{
+		timestamp: timestamp,
+		keySize:   uint32(len(key)),
+		valueSize: valueSize,
+		hasExpiry: expireAt != 0,
+		expireAt:  expireAt,
+	}
+	header.encode(buf)
+	keyOffset := RecordHeaderSize + header.extSize()
+	copy(buf[keyOffset:], key)
+	copy(buf[keyOffset+len(key):], value)
+
+	header.crc = crc32.ChecksumIEEE(buf[4:])
+	binary.BigEndian.PutUint32(buf[0:4], header.crc)
+	return buf
+}
+
+// 解析完整记录，校验失败返回false
+func decodeRecord(buf []byte) (header recordHeader, key string, value []byte, ok bool) {
+	if len(buf) < RecordHeaderSize {
+		return header, "", nil, false
+	}
+	header.decode(buf)
+	if int64(len(buf)) != header.size() {
+		return header, "", nil, false
+	}
+	if crc32.ChecksumIEEE(buf[4:]) != header.crc {
+		return header, "", nil, false
+	}
+
+	keyOffset := uint32(RecordHeaderSize + header.extSize())
+	key = string(buf[keyOffset : keyOffset+header.keySize])
+	value = buf[keyOffset+header.keySize:]
+	return header, key, value, true
+}
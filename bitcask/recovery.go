@@ -0,0 +1,253 @@
+package bitcask
+
+import (
+	"bufio"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 列出目录下所有编号的数据文件，按从旧到新排序
+func listDataFiles(dir string) ([]string, error) {
+	paths, globErr := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	if globErr != nil {
+		return nil, globErr
+	}
+
+	fileNames := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if _, ok := parseDataFileID(filepath.Base(path)); ok {
+			fileNames = append(fileNames, filepath.Base(path))
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+// 将旧版按日期命名的数据文件(bitcask_YYYY-MM-DD.zzkv)按从旧到新改为编号文件
+// 中途崩溃时已改名的文件编号较小，剩余文件接着已有的最大编号继续，顺序不变
+func migrateLegacyDataFiles(dir string, readOnly bool) error {
+	paths, globErr := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	if globErr != nil {
+		return globErr
+	}
+
+	var maxFileID uint32
+	legacyFileNames := make([]string, 0)
+	for _, path := range paths {
+		fileName := filepath.Base(path)
+		if fileID, ok := parseDataFileID(fileName); ok {
+			if fileID > maxFileID {
+				maxFileID = fileID
+			}
+			continue
+		}
+		legacyFileNames = append(legacyFileNames, fileName)
+	}
+	if len(legacyFileNames) == 0 {
+		return nil
+	}
+	if readOnly {
+		return ErrNeedMigration
+	}
+	sort.Strings(legacyFileNames)
+
+	for _, legacyFileName := range legacyFileNames {
+		maxFileID++
+		fileName := dataFileName(maxFileID)
+		_ = os.Rename(filepath.Join(dir, hintFileName(legacyFileName)), filepath.Join(dir, hintFileName(fileName)))
+		renameErr := os.Rename(filepath.Join(dir, legacyFileName), filepath.Join(dir, fileName))
+		if renameErr != nil {
+			return renameErr
+		}
+	}
+	return syncDir(dir)
+}
+
+// 顺序扫描数据文件，对每条完整且校验通过的记录回调fn
+// 文件末尾的残缺记录视为崩溃留下的尾部，停止扫描并返回有效数据的长度，由调用方决定是否截掉
+// 之后还有数据的记录校验失败说明文件已损坏，返回ChecksumError
+func scanDataFile(path string, fn func(header recordHeader, key string, offset int64)) (int64, error) {
+	fileHandle, openErr := os.Open(path)
+	if openErr != nil {
+		return 0, openErr
+	}
+	defer fileHandle.Close()
+
+	info, statErr := fileHandle.Stat()
+	if statErr != nil {
+		return 0, statErr
+	}
+
+	reader := bufio.NewReaderSize(fileHandle, 1<<20)
+	headerBuf := make([]byte, RecordHeaderSize)
+	var offset int64
+	for {
+		_, readErr := io.ReadFull(reader, headerBuf)
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return offset, nil
+		}
+		if readErr != nil {
+			return offset, readErr
+		}
+
+		var header recordHeader
+		header.decode(headerBuf)
+		size := header.size()
+		// 长度超出文件剩余部分，说明记录被截断或头部本身已损坏，之后还能找到完整记录的是后者
+		if offset+size > info.Size() {
+			if hasRecordAfter(fileHandle, offset+1, info.Size()) {
+				return offset, &ChecksumError{FileName: filepath.Base(path), Offset: uint64(offset)}
+			}
+			return offset, nil
+		}
+
+		body := make([]byte, size-RecordHeaderSize)
+		_, readErr = io.ReadFull(reader, body)
+		if readErr != nil {
+			return offset, nil
+		}
+
+		crc := crc32.ChecksumIEEE(headerBuf[4:])
+		crc = crc32.Update(crc, crc32.IEEETable, body)
+		if crc != header.crc {
+			if offset+size < info.Size() {
+				return offset, &ChecksumError{FileName: filepath.Base(path), Offset: uint64(offset)}
+			}
+			return offset, nil
+		}
+
+		if header.hasExpiry {
+			header.decodeExpiry(body)
+		}
+		keyOffset := uint32(header.extSize())
+		fn(header, string(body[keyOffset:keyOffset+header.keySize]), offset)
+		offset += size
+	}
+}
+
+// [start, end)范围内是否存在一条完整且校验通过的记录，只在发现残缺记录时调用
+// 崩溃留下的尾部不足一条记录，逐字节尝试的开销很小
+func hasRecordAfter(fileHandle *os.File, start int64, end int64) bool {
+	if start >= end {
+		return false
+	}
+	buf := make([]byte, end-start)
+	_, readErr := fileHandle.ReadAt(buf, start)
+	if readErr != nil && readErr != io.EOF {
+		return false
+	}
+
+	for i := 0; i+RecordHeaderSize <= len(buf); i++ {
+		var header recordHeader
+		header.decode(buf[i:])
+		size := header.size()
+		if int64(i)+size > int64(len(buf)) {
+			continue
+		}
+		if _, _, _, ok := decodeRecord(buf[i : int64(i)+size]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// 从旧到新回放所有数据文件重建keydir，截掉崩溃留下的残缺尾部
+// 只有最后一个数据文件可能有残缺尾部，切换文件前旧文件已sync，其他文件中的残缺或校验失败都返回ChecksumError
+// 不可变文件有hint时直接加载hint，没有则扫描并在后台补写hint
+// 只读打开时不修改任何文件，残缺尾部只是忽略
+func (db *DB) recover() error {
+	// 清理合并或写hint中途崩溃留下的临时文件
+	if !db.opts.ReadOnly {
+		tmpFiles, globErr := filepath.Glob(filepath.Join(db.dir, "bitcask_*.tmp"))
+		if globErr != nil {
+			return globErr
+		}
+		mergeFiles, _ := filepath.Glob(filepath.Join(db.dir, "bitcask_*.merge"))
+		for _, tmpFile := range append(tmpFiles, mergeFiles...) {
+			_ = os.Remove(tmpFile)
+		}
+	}
+
+	fileNames, listErr := listDataFiles(db.dir)
+	if listErr != nil {
+		return listErr
+	}
+
+	// 停机期间过期的key与已删除的key一样处理
+	now := time.Now().UnixNano()
+	for i, fileName := range fileNames {
+		immutable := fileName != db.activeFile
+		if immutable {
+			entries, ok := readHintFile(db.dir, fileName)
+			if ok {
+				for _, entry := range entries {
+					if entry.valueSize == tombstoneValueSize || isExpired(entry.expireAt, now) {
+						delete(db.keydir, entry.key)
+						continue
+					}
+					db.keydir[entry.key] = IndexItem{
+						Key:        entry.key,
+						CreateTime: entry.timestamp,
+						ExpireAt:   entry.expireAt,
+						PosItem:    Position{FileName: fileName, Pos: uint64(entry.offset), Size: entry.valueSize},
+					}
+				}
+				continue
+			}
+		}
+
+		path := filepath.Join(db.dir, fileName)
+		validSize, scanErr := scanDataFile(path, func(header recordHeader, key string, offset int64) {
+			if header.isTombstone() || header.isExpired(now) {
+				delete(db.keydir, key)
+				return
+			}
+			db.keydir[key] = IndexItem{
+				Key:        key,
+				CreateTime: header.timestamp,
+				ExpireAt:   header.expireAt,
+				PosItem:    Position{FileName: fileName, Pos: uint64(offset), Size: header.valueSize},
+			}
+		})
+		if scanErr != nil {
+			return scanErr
+		}
+
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return statErr
+		}
+		if info.Size() > validSize && i != len(fileNames)-1 {
+			return &ChecksumError{FileName: fileName, Offset: uint64(validSize)}
+		}
+		if info.Size() > validSize && !db.opts.ReadOnly {
+			truncateErr := os.Truncate(path, validSize)
+			if truncateErr != nil {
+				return truncateErr
+			}
+			db.truncatedBytes += info.Size() - validSize
+		}
+
+		if immutable && !db.opts.ReadOnly {
+			db.generateHint(fileName)
+		}
+	}
+
+	// 统计各文件大小及存活数据
+	for _, fileName := range fileNames {
+		info, statErr := os.Stat(filepath.Join(db.dir, fileName))
+		if statErr != nil {
+			return statErr
+		}
+		db.fileStat(fileName).size = info.Size()
+	}
+	for _, item := range db.keydir {
+		db.fileStat(item.PosItem.FileName).liveBytes += item.recordSize()
+	}
+
+	return nil
+}
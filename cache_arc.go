@@ -0,0 +1,261 @@
+package zzkv
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ARC中条目所在的队列
+const (
+	arcT1 = iota // 只访问过一次的常驻条目
+	arcT2        // 访问过多次的常驻条目
+	arcB1        // 从T1淘汰的幽灵条目，只保留key
+	arcB2        // 从T2淘汰的幽灵条目，只保留key
+)
+
+type arcEntry struct {
+	key   string
+	value []byte
+	queue int
+}
+
+// 自适应替换缓存(Adaptive Replacement Cache)的单个分片
+// 根据幽灵条目的命中情况，自动调整留给新条目(T1)和高频条目(T2)的容量，一次性扫描只会冲掉T1
+type arcShard struct {
+	capacity  int
+	maxBytes  int64
+	usedBytes int64
+	target    int // T1的目标大小
+	items     map[string]*list.Element
+	queues    [4]*list.List // 头部为最近访问
+	stats     CacheStats
+	onEvict   func(key string)
+	sync.Mutex
+}
+
+func (s *arcShard) Set(key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.items[key]
+	if ok && s.resident(element) {
+		// 再次写入视为一次访问
+		entry := element.Value.(*arcEntry)
+		s.usedBytes += int64(len(value)) - int64(len(entry.value))
+		entry.value = value
+		s.moveTo(element, arcT2)
+		s.shrink()
+		return nil
+	}
+	// 单个value超过字节上限时不缓存，避免把其他条目全部挤掉
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		return nil
+	}
+
+	switch {
+	case ok && element.Value.(*arcEntry).queue == arcB1:
+		// 最近从T1淘汰的key又来了，说明T1太小
+		s.target = minInt(s.capacity, s.target+maxInt(s.queues[arcB2].Len()/s.queues[arcB1].Len(), 1))
+		s.makeRoom(false)
+		s.reuse(element, value, arcT2)
+	case ok:
+		// 最近从T2淘汰的key又来了，说明T2太小
+		s.target = maxInt(0, s.target-maxInt(s.queues[arcB1].Len()/s.queues[arcB2].Len(), 1))
+		s.makeRoom(true)
+		s.reuse(element, value, arcT2)
+	default:
+		t1, b1 := s.queues[arcT1].Len(), s.queues[arcB1].Len()
+		total := t1 + b1 + s.queues[arcT2].Len() + s.queues[arcB2].Len()
+		if t1+b1 >= s.capacity {
+			if t1 < s.capacity {
+				s.removeElement(s.queues[arcB1].Back())
+				s.makeRoom(false)
+			} else {
+				// T1已占满整个缓存，直接丢弃不留幽灵
+				evicted := s.queues[arcT1].Back()
+				s.removeElement(evicted)
+				s.evicted(evicted.Value.(*arcEntry).key)
+			}
+		} else if total >= s.capacity {
+			if total >= 2*s.capacity {
+				s.removeElement(s.queues[arcB2].Back())
+			}
+			s.makeRoom(false)
+		}
+		entry := &arcEntry{key: key, value: value, queue: arcT1}
+		s.items[key] = s.queues[arcT1].PushFront(entry)
+		s.usedBytes += int64(len(value))
+	}
+	s.shrink()
+	return nil
+}
+
+func (s *arcShard) Get(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.items[key]
+	if !ok || !s.resident(element) {
+		s.stats.Misses++
+		return nil, ErrNotFound
+	}
+	s.stats.Hits++
+	s.moveTo(element, arcT2)
+	return element.Value.(*arcEntry).value, nil
+}
+
+func (s *arcShard) IsExist(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.items[key]
+	return ok && s.resident(element)
+}
+
+func (s *arcShard) Erase(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.removeElement(element)
+	}
+	return nil
+}
+
+func (s *arcShard) Stats() CacheStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	stats.Entries = s.queues[arcT1].Len() + s.queues[arcT2].Len()
+	stats.Bytes = s.usedBytes
+	return stats
+}
+
+func (s *arcShard) resident(element *list.Element) bool {
+	queue := element.Value.(*arcEntry).queue
+	return queue == arcT1 || queue == arcT2
+}
+
+// 常驻条目已满时腾出一个位置
+func (s *arcShard) makeRoom(ghostB2 bool) {
+	if s.queues[arcT1].Len()+s.queues[arcT2].Len() >= s.capacity {
+		s.replace(ghostB2)
+	}
+}
+
+// 淘汰一个常驻条目到对应的幽灵队列，ghostB2表示本次访问命中的是B2
+func (s *arcShard) replace(ghostB2 bool) {
+	t1 := s.queues[arcT1].Len()
+	if t1 > 0 && (t1 > s.target || (ghostB2 && t1 == s.target)) {
+		s.evict(s.queues[arcT1].Back())
+	} else if s.queues[arcT2].Len() > 0 {
+		s.evict(s.queues[arcT2].Back())
+	} else if t1 > 0 {
+		s.evict(s.queues[arcT1].Back())
+	}
+}
+
+// 常驻条目转为幽灵条目，释放value
+func (s *arcShard) evict(element *list.Element) {
+	entry := element.Value.(*arcEntry)
+	s.usedBytes -= int64(len(entry.value))
+	entry.value = nil
+	if entry.queue == arcT1 {
+		s.moveTo(element, arcB1)
+	} else {
+		s.moveTo(element, arcB2)
+	}
+	s.evicted(entry.key)
+}
+
+func (s *arcShard) SetEvictHandler(fn func(key string)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onEvict = fn
+}
+
+func (s *arcShard) evicted(key string) {
+	s.stats.Evictions++
+	if s.onEvict != nil {
+		s.onEvict(key)
+	}
+}
+
+// 超出字节上限时继续淘汰
+func (s *arcShard) shrink() {
+	for s.maxBytes > 0 && s.usedBytes > s.maxBytes {
+		s.replace(false)
+	}
+	// 幽灵队列不超过容量
+	for s.queues[arcB1].Len()+s.queues[arcB2].Len() > s.capacity {
+		if s.queues[arcB1].Len() > s.queues[arcB2].Len() {
+			s.removeElement(s.queues[arcB1].Back())
+		} else {
+			s.removeElement(s.queues[arcB2].Back())
+		}
+	}
+}
+
+func (s *arcShard) reuse(element *list.Element, value []byte, queue int) {
+	element.Value.(*arcEntry).value = value
+	s.usedBytes += int64(len(value))
+	s.moveTo(element, queue)
+}
+
+func (s *arcShard) moveTo(element *list.Element, queue int) {
+	entry := element.Value.(*arcEntry)
+	if entry.queue == queue {
+		s.queues[queue].MoveToFront(element)
+		return
+	}
+	s.queues[entry.queue].Remove(element)
+	entry.queue = queue
+	s.items[entry.key] = s.queues[queue].PushFront(entry)
+}
+
+func (s *arcShard) removeElement(element *list.Element) {
+	if element == nil {
+		return
+	}
+	entry := element.Value.(*arcEntry)
+	s.queues[entry.queue].Remove(element)
+	delete(s.items, entry.key)
+	s.usedBytes -= int64(len(entry.value))
+}
+
+func newARCShard(maxEntries int, maxBytes int64) cacheShard {
+	shard := &arcShard{
+		capacity: maxEntries,
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+	}
+	for i := range shard.queues {
+		shard.queues[i] = list.New()
+	}
+	return shard
+}
+
+// 分片的ARC缓存，maxEntries为0时使用DefaultCacheMaxEntries，maxBytes为0表示不限制，shards为0时使用DefaultCacheShards
+// 字节上限平分到各分片，单个value超过maxBytes/shards时不缓存
+func NewARCCacheStorager(maxEntries int, maxBytes int64, shards int) *ShardedCacheStorager {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	return newShardedCacheStorager(maxEntries, maxBytes, shards, newARCShard)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,134 @@
+package zzkv
+
+import (
+	"container/list"
+	"sync"
+)
+
+// 缓存统计
+type CacheStats struct {
+	Hits      int64 // 命中次数
+	Misses    int64 // 未命中次数
+	Evictions int64 // 因超出容量被淘汰的条目数
+	Entries   int   // 当前条目数
+	Bytes     int64 // 当前value总字节数
+}
+
+type lruEntry struct {
+	key   string
+	value []byte
+}
+
+// 按条目数和字节数限制容量的LRU缓存，超出任一限制时淘汰最久未访问的条目
+type LRUCacheStorager struct {
+	maxEntries int
+	maxBytes   int64
+	usedBytes  int64
+	items      map[string]*list.Element
+	order      *list.List // 头部为最近访问
+	stats      CacheStats
+	onEvict    func(key string)
+	sync.Mutex
+}
+
+func (s *LRUCacheStorager) Set(key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.removeElement(element)
+	}
+	// 单个value超过字节上限时不缓存，避免把其他条目全部挤掉
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		return nil
+	}
+
+	s.items[key] = s.order.PushFront(&lruEntry{key: key, value: value})
+	s.usedBytes += int64(len(value))
+	for s.overflow() {
+		evicted := s.order.Back()
+		s.removeElement(evicted)
+		s.evicted(evicted.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+func (s *LRUCacheStorager) Get(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	element, ok := s.items[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, ErrNotFound
+	}
+	s.stats.Hits++
+	s.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, nil
+}
+
+func (s *LRUCacheStorager) IsExist(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.items[key]
+	return ok
+}
+
+func (s *LRUCacheStorager) Erase(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.removeElement(element)
+	}
+	return nil
+}
+
+func (s *LRUCacheStorager) Stats() CacheStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	stats.Entries = len(s.items)
+	stats.Bytes = s.usedBytes
+	return stats
+}
+
+// 设置淘汰回调，持有缓存锁时调用，不能阻塞，也不能再访问缓存
+func (s *LRUCacheStorager) SetEvictHandler(fn func(key string)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onEvict = fn
+}
+
+func (s *LRUCacheStorager) evicted(key string) {
+	s.stats.Evictions++
+	if s.onEvict != nil {
+		s.onEvict(key)
+	}
+}
+
+func (s *LRUCacheStorager) overflow() bool {
+	if s.maxEntries > 0 && len(s.items) > s.maxEntries {
+		return true
+	}
+	return s.maxBytes > 0 && s.usedBytes > s.maxBytes
+}
+
+func (s *LRUCacheStorager) removeElement(element *list.Element) {
+	entry := s.order.Remove(element).(*lruEntry)
+	delete(s.items, entry.key)
+	s.usedBytes -= int64(len(entry.value))
+}
+
+// maxEntries、maxBytes为0表示该项不限制
+func NewLRUCacheStorager(maxEntries int, maxBytes int64) *LRUCacheStorager {
+	return &LRUCacheStorager{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
@@ -0,0 +1,94 @@
+package zzkv
+
+import (
+	"hash/fnv"
+)
+
+const (
+	DefaultCacheShards     = 16
+	DefaultCacheMaxEntries = 1 << 16 // 扫描抗性策略需要确定的条目上限，未配置时使用
+)
+
+// 带统计的缓存分片
+type cacheShard interface {
+	CacheStorager
+	Stats() CacheStats
+	SetEvictHandler(fn func(key string))
+}
+
+// 按key哈希分成多个互不相关的分片，每个分片各自加锁，降低锁竞争
+type ShardedCacheStorager struct {
+	shards []cacheShard
+}
+
+func (s *ShardedCacheStorager) shard(key string) cacheShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return s.shards[hash.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *ShardedCacheStorager) Set(key string, value []byte) error {
+	return s.shard(key).Set(key, value)
+}
+
+func (s *ShardedCacheStorager) Get(key string) ([]byte, error) {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedCacheStorager) IsExist(key string) bool {
+	return s.shard(key).IsExist(key)
+}
+
+func (s *ShardedCacheStorager) Erase(key string) error {
+	return s.shard(key).Erase(key)
+}
+
+// 所有分片的统计之和
+func (s *ShardedCacheStorager) Stats() CacheStats {
+	var stats CacheStats
+	for _, shard := range s.shards {
+		shardStats := shard.Stats()
+		stats.Hits += shardStats.Hits
+		stats.Misses += shardStats.Misses
+		stats.Evictions += shardStats.Evictions
+		stats.Entries += shardStats.Entries
+		stats.Bytes += shardStats.Bytes
+	}
+	return stats
+}
+
+// 设置所有分片的淘汰回调，见LRUCacheStorager.SetEvictHandler
+func (s *ShardedCacheStorager) SetEvictHandler(fn func(key string)) {
+	for _, shard := range s.shards {
+		shard.SetEvictHandler(fn)
+	}
+}
+
+// 将容量平分到各分片，余数分给前面的分片
+// 分片数不超过条目上限及字节上限，每个分片至少有1个条目和1字节，分片的0字节上限不会变成不限制
+// 各分片独立限制字节数，大于单个分片字节上限的value不会被缓存
+func newShardedCacheStorager(maxEntries int, maxBytes int64, shards int, newShard func(maxEntries int, maxBytes int64) cacheShard) *ShardedCacheStorager {
+	if shards <= 0 {
+		shards = DefaultCacheShards
+	}
+	if shards > maxEntries {
+		shards = maxEntries
+	}
+	if maxBytes > 0 && int64(shards) > maxBytes {
+		shards = int(maxBytes)
+	}
+
+	result := &ShardedCacheStorager{shards: make([]cacheShard, shards)}
+	for i := range result.shards {
+		shardEntries := maxEntries / shards
+		if i < maxEntries%shards {
+			shardEntries++
+		}
+		shardBytes := maxBytes / int64(shards)
+		if int64(i) < maxBytes%int64(shards) {
+			shardBytes++
+		}
+		result.shards[i] = newShard(shardEntries, shardBytes)
+	}
+	return result
+}
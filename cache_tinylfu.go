@@ -0,0 +1,287 @@
+package zzkv
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+)
+
+const (
+	sketchDepth      = 4
+	sketchMaxCount   = 15 // 计数上限，与4位计数器一致
+	sketchSampleRate = 10 // 累计访问达到容量的该倍数时所有计数减半，让旧的热点逐渐冷却
+)
+
+// Count-Min Sketch，用固定内存估计每个key的访问频率
+type frequencySketch struct {
+	counters   [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newFrequencySketch(capacity int) *frequencySketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	sketch := &frequencySketch{mask: uint64(width - 1), sampleSize: sketchSampleRate * capacity}
+	for i := range sketch.counters {
+		sketch.counters[i] = make([]uint8, width)
+	}
+	return sketch
+}
+
+// 每一行的下标由同一个64位哈希的两半组合得到
+func (sketch *frequencySketch) indexes(key string) [sketchDepth]uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+	sum := hash.Sum64()
+	low, high := sum&0xffffffff, sum>>32|1
+
+	var result [sketchDepth]uint64
+	for i := range result {
+		result[i] = (low + uint64(i)*high) & sketch.mask
+	}
+	return result
+}
+
+func (sketch *frequencySketch) increment(key string) {
+	for i, index := range sketch.indexes(key) {
+		if sketch.counters[i][index] < sketchMaxCount {
+			sketch.counters[i][index]++
+		}
+	}
+
+	sketch.additions++
+	if sketch.additions >= sketch.sampleSize {
+		for i := range sketch.counters {
+			for j := range sketch.counters[i] {
+				sketch.counters[i][j] >>= 1
+			}
+		}
+		sketch.additions /= 2
+	}
+}
+
+func (sketch *frequencySketch) frequency(key string) uint8 {
+	result := uint8(sketchMaxCount)
+	for i, index := range sketch.indexes(key) {
+		if sketch.counters[i][index] < result {
+			result = sketch.counters[i][index]
+		}
+	}
+	return result
+}
+
+// W-TinyLFU中条目所在的区域
+const (
+	tinyLFUWindow    = iota // 新条目先进入的小LRU窗口
+	tinyLFUProbation        // 主区中只命中过一次的条目
+	tinyLFUProtected        // 主区中多次命中的条目
+)
+
+type tinyLFUEntry struct {
+	key    string
+	value  []byte
+	region int
+}
+
+// W-TinyLFU缓存的单个分片
+// 新条目先进入约占1%容量的LRU窗口，被挤出窗口时与主区的淘汰候选比较访问频率，频率更高的留下
+// 一次性扫描的key频率很低，进不了主区，不会冲掉热点
+type tinyLFUShard struct {
+	windowCap    int
+	protectedCap int
+	mainCap      int
+	maxBytes     int64
+	usedBytes    int64
+	items        map[string]*list.Element
+	regions      [3]*list.List // 头部为最近访问
+	sketch       *frequencySketch
+	stats        CacheStats
+	onEvict      func(key string)
+	sync.Mutex
+}
+
+func (s *tinyLFUShard) Set(key string, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sketch.increment(key)
+	if element, ok := s.items[key]; ok {
+		entry := element.Value.(*tinyLFUEntry)
+		s.usedBytes += int64(len(value)) - int64(len(entry.value))
+		entry.value = value
+		s.touch(element)
+		s.shrink()
+		return nil
+	}
+	// 单个value超过字节上限时不缓存，避免把其他条目全部挤掉
+	if s.maxBytes > 0 && int64(len(value)) > s.maxBytes {
+		return nil
+	}
+
+	entry := &tinyLFUEntry{key: key, value: value, region: tinyLFUWindow}
+	s.items[key] = s.regions[tinyLFUWindow].PushFront(entry)
+	s.usedBytes += int64(len(value))
+	if s.regions[tinyLFUWindow].Len() > s.windowCap {
+		s.admit(s.regions[tinyLFUWindow].Back())
+	}
+	s.shrink()
+	return nil
+}
+
+func (s *tinyLFUShard) Get(key string) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	// 未命中也计入频率，稍后写入时才能判断是否值得留下
+	s.sketch.increment(key)
+	element, ok := s.items[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, ErrNotFound
+	}
+	s.stats.Hits++
+	s.touch(element)
+	return element.Value.(*tinyLFUEntry).value, nil
+}
+
+func (s *tinyLFUShard) IsExist(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, ok := s.items[key]
+	return ok
+}
+
+func (s *tinyLFUShard) Erase(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.removeElement(element)
+	}
+	return nil
+}
+
+func (s *tinyLFUShard) Stats() CacheStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	stats.Entries = len(s.items)
+	stats.Bytes = s.usedBytes
+	return stats
+}
+
+// 命中后调整位置，probation中的条目晋升到protected
+func (s *tinyLFUShard) touch(element *list.Element) {
+	entry := element.Value.(*tinyLFUEntry)
+	if entry.region != tinyLFUProbation {
+		s.regions[entry.region].MoveToFront(element)
+		return
+	}
+
+	s.moveTo(element, tinyLFUProtected)
+	if s.regions[tinyLFUProtected].Len() > s.protectedCap {
+		s.moveTo(s.regions[tinyLFUProtected].Back(), tinyLFUProbation)
+	}
+}
+
+// 窗口挤出的候选条目与主区的淘汰候选比较频率，决定谁留下
+func (s *tinyLFUShard) admit(candidate *list.Element) {
+	if s.regions[tinyLFUProbation].Len()+s.regions[tinyLFUProtected].Len() < s.mainCap {
+		s.moveTo(candidate, tinyLFUProbation)
+		return
+	}
+
+	victim := s.regions[tinyLFUProbation].Back()
+	if victim == nil {
+		victim = s.regions[tinyLFUProtected].Back()
+	}
+	candidateKey := candidate.Value.(*tinyLFUEntry).key
+	if victim == nil {
+		s.removeElement(candidate)
+		s.evicted(candidateKey)
+		return
+	}
+	victimKey := victim.Value.(*tinyLFUEntry).key
+	if s.sketch.frequency(candidateKey) > s.sketch.frequency(victimKey) {
+		s.removeElement(victim)
+		s.moveTo(candidate, tinyLFUProbation)
+		s.evicted(victimKey)
+	} else {
+		s.removeElement(candidate)
+		s.evicted(candidateKey)
+	}
+}
+
+// 超出字节上限时按probation、window、protected的顺序淘汰
+func (s *tinyLFUShard) shrink() {
+	for s.maxBytes > 0 && s.usedBytes > s.maxBytes {
+		for _, region := range []int{tinyLFUProbation, tinyLFUWindow, tinyLFUProtected} {
+			if victim := s.regions[region].Back(); victim != nil {
+				s.removeElement(victim)
+				s.evicted(victim.Value.(*tinyLFUEntry).key)
+				break
+			}
+		}
+	}
+}
+
+func (s *tinyLFUShard) SetEvictHandler(fn func(key string)) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.onEvict = fn
+}
+
+func (s *tinyLFUShard) evicted(key string) {
+	s.stats.Evictions++
+	if s.onEvict != nil {
+		s.onEvict(key)
+	}
+}
+
+func (s *tinyLFUShard) moveTo(element *list.Element, region int) {
+	entry := element.Value.(*tinyLFUEntry)
+	s.regions[entry.region].Remove(element)
+	entry.region = region
+	s.items[entry.key] = s.regions[region].PushFront(entry)
+}
+
+func (s *tinyLFUShard) removeElement(element *list.Element) {
+	entry := element.Value.(*tinyLFUEntry)
+	s.regions[entry.region].Remove(element)
+	delete(s.items, entry.key)
+	s.usedBytes -= int64(len(entry.value))
+}
+
+// 窗口约占1%，主区中protected占80%
+func newTinyLFUShard(maxEntries int, maxBytes int64) cacheShard {
+	windowCap := maxInt(1, maxEntries/100)
+	mainCap := maxEntries - windowCap
+	shard := &tinyLFUShard{
+		windowCap:    windowCap,
+		protectedCap: mainCap * 8 / 10,
+		mainCap:      mainCap,
+		maxBytes:     maxBytes,
+		items:        make(map[string]*list.Element),
+		sketch:       newFrequencySketch(maxEntries),
+	}
+	for i := range shard.regions {
+		shard.regions[i] = list.New()
+	}
+	return shard
+}
+
+// 分片的W-TinyLFU缓存，maxEntries为0时使用DefaultCacheMaxEntries，maxBytes为0表示不限制，shards为0时使用DefaultCacheShards
+// 字节上限平分到各分片，单个value超过maxBytes/shards时不缓存
+func NewTinyLFUCacheStorager(maxEntries int, maxBytes int64, shards int) *ShardedCacheStorager {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheMaxEntries
+	}
+	return newShardedCacheStorager(maxEntries, maxBytes, shards, newTinyLFUShard)
+}
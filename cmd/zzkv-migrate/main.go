@@ -0,0 +1,45 @@
+// 将旧版直接以key命名文件的数据目录迁移为编码后的分片布局
+//
+// 用法: zzkv-migrate -dir <数据目录>
+// 迁移期间会锁定数据目录，需先停止使用该目录的服务
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zzkv"
+)
+
+func main() {
+	dir := flag.String("dir", "", "data directory of the file-per-key store (required)")
+	flag.Parse()
+	// 不提供默认值，避免误迁移当前目录
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "missing -dir")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := zzkv.DefaultOptions()
+	opts.DataDir = *dir
+	s, err := zzkv.NewDefaultPstStorager(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open data dir. errMsg[%s]\n", err)
+		os.Exit(1)
+	}
+
+	migrated, migrateErr := s.MigrateLegacyKeys()
+	fmt.Printf("migrated %d keys\n", migrated)
+	closeErr := s.Close()
+	if migrateErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate. errMsg[%s]\n", migrateErr)
+	}
+	if closeErr != nil {
+		fmt.Fprintf(os.Stderr, "failed to close data dir. errMsg[%s]\n", closeErr)
+	}
+	if migrateErr != nil || closeErr != nil {
+		os.Exit(1)
+	}
+}
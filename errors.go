@@ -0,0 +1,34 @@
+package zzkv
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/zzkv/bitcask"
+)
+
+// 可用errors.Is判断的错误
+var (
+	ErrNotFound = errors.New("zzkv: key not found")
+	ErrCorrupt  = errors.New("zzkv: data corrupted")
+	ErrClosed   = errors.New("zzkv: storage closed")
+)
+
+// 将bitcask的错误转换为对应的zzkv错误，保留原始信息
+func convertBitcaskError(err error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case *bitcask.ChecksumError:
+		return fmt.Errorf("%w: %s", ErrCorrupt, err)
+	}
+
+	switch err {
+	case bitcask.ErrKeyNotFound:
+		return ErrNotFound
+	case bitcask.ErrClosed:
+		return ErrClosed
+	}
+	return err
+}
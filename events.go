@@ -0,0 +1,136 @@
+package zzkv
+
+import (
+	"sync"
+)
+
+const DefaultEventQueueSize = 1024
+
+// 事件统计
+type EventStats struct {
+	Delivered int64 // 已交给回调的事件数
+	Dropped   int64 // 队列已满被丢弃的事件数
+}
+
+type eventKind int
+
+const (
+	eventExpire eventKind = iota // 过期key被后台清理删除
+	eventEvict                   // 缓存因超出容量淘汰了key
+)
+
+type event struct {
+	kind eventKind
+	key  string
+}
+
+// 有界的事件队列，由单个后台协程按顺序调用回调
+// 发布方不会阻塞，队列满时丢弃事件并计数，回调执行慢不会拖慢读写
+type eventQueue struct {
+	events   chan event
+	onExpire []func(key string)
+	onEvict  []func(key string)
+	stats    EventStats
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	sync.Mutex
+}
+
+func newEventQueue(size int) *eventQueue {
+	if size <= 0 {
+		size = DefaultEventQueueSize
+	}
+	result := &eventQueue{
+		events:   make(chan event, size),
+		stopChan: make(chan struct{}),
+	}
+	result.wg.Add(1)
+	go result.run()
+	return result
+}
+
+func (q *eventQueue) subscribe(kind eventKind, fn func(key string)) {
+	q.Lock()
+	defer q.Unlock()
+
+	if kind == eventExpire {
+		q.onExpire = append(q.onExpire, fn)
+	} else {
+		q.onEvict = append(q.onEvict, fn)
+	}
+}
+
+// 没有注册回调的事件直接忽略，停止后发布的事件也被忽略
+func (q *eventQueue) publish(kind eventKind, key string) {
+	if len(q.handlers(kind)) == 0 {
+		return
+	}
+	select {
+	case <-q.stopChan:
+		return
+	default:
+	}
+
+	select {
+	case q.events <- event{kind: kind, key: key}:
+	default:
+		q.Lock()
+		q.stats.Dropped++
+		q.Unlock()
+	}
+}
+
+func (q *eventQueue) handlers(kind eventKind) []func(key string) {
+	q.Lock()
+	defer q.Unlock()
+
+	if kind == eventExpire {
+		return q.onExpire
+	}
+	return q.onEvict
+}
+
+func (q *eventQueue) run() {
+	defer q.wg.Done()
+	for {
+		select {
+		case e := <-q.events:
+			q.deliver(e)
+		case <-q.stopChan:
+			// 交付停止前已入队的事件
+			for {
+				select {
+				case e := <-q.events:
+					q.deliver(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (q *eventQueue) deliver(e event) {
+	for _, fn := range q.handlers(e.kind) {
+		fn(e.key)
+	}
+	q.Lock()
+	q.stats.Delivered++
+	q.Unlock()
+}
+
+// 交付完已入队的事件后停止，可重复调用
+func (q *eventQueue) stop() {
+	q.stopOnce.Do(func() {
+		close(q.stopChan)
+	})
+	q.wg.Wait()
+}
+
+func (q *eventQueue) eventStats() EventStats {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.stats
+}
@@ -1,8 +1,10 @@
 module github.com/zzkv
 
-go 1.12
+go 1.18
 
 require (
 	github.com/gogf/gf v1.9.10
 	github.com/pkg/errors v0.8.1
 )
+
+require github.com/fatih/structs v1.1.0 // indirect
@@ -0,0 +1,77 @@
+package zzkv
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	keyFileSuffix     = ".zzkv"
+	longKeySuffix     = ".key"
+	expirySuffix      = ".ttl" // 过期时间文件，内容为8字节大端的UnixNano
+	tmpFileSuffix     = ".tmp" // 原子写入的临时文件，崩溃残留会在下次写同一个key时覆盖
+	longKeyPrefix     = "_"    // 不在编码字母表中，哈希文件名不会与编码文件名冲突
+	maxKeyNameSegment = 200    // 单级文件名长度上限，低于常见文件系统的255
+	maxEncodedKeyLen  = 1000   // 编码后超过该长度的key改用哈希文件名，避免超出路径长度上限
+)
+
+// 小写base32hex编码，只含数字和小写字母，任意字节串都能得到合法文件名，在大小写不敏感的文件系统上也不会冲突
+var keyEncoding = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// key对应的相对路径: <分片目录>/<编码后的key>.zzkv
+// 分片目录为key哈希的低8位，编码结果过长时按段拆成多级目录
+// 编码后仍然过长的key使用 <分片目录>/_<sha256>.zzkv，hashed返回true，原始key另存于同名的.key文件
+func encodeKeyPath(key string) (path string, hashed bool) {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	shard := fmt.Sprintf("%02x", hash.Sum32()&0xff)
+
+	encoded := keyEncoding.EncodeToString([]byte(key))
+	if len(encoded) > maxEncodedKeyLen {
+		digest := sha256.Sum256([]byte(key))
+		return filepath.Join(shard, longKeyPrefix+hex.EncodeToString(digest[:])+keyFileSuffix), true
+	}
+
+	segments := []string{shard}
+	for len(encoded) > maxKeyNameSegment {
+		segments = append(segments, encoded[:maxKeyNameSegment])
+		encoded = encoded[maxKeyNameSegment:]
+	}
+	segments = append(segments, encoded+keyFileSuffix)
+	return filepath.Join(segments...), false
+}
+
+// encodeKeyPath的逆过程，path为数据目录下的相对路径
+// 不是key文件或无法解码时ok返回false，哈希文件名需由调用方读取.key文件得到原始key
+func decodeKeyPath(path string) (key string, hashed bool, ok bool) {
+	segments := strings.Split(filepath.ToSlash(path), "/")
+	last := segments[len(segments)-1]
+	if len(segments) < 2 || !strings.HasSuffix(last, keyFileSuffix) {
+		return "", false, false
+	}
+	if len(segments) == 2 && strings.HasPrefix(last, longKeyPrefix) {
+		return "", true, true
+	}
+
+	encoded := strings.Join(segments[1:], "")
+	decoded, decodeErr := keyEncoding.DecodeString(strings.TrimSuffix(encoded, keyFileSuffix))
+	if decodeErr != nil {
+		return "", false, false
+	}
+	return string(decoded), false, true
+}
+
+// 哈希文件名对应的原始key文件
+func longKeyPath(path string) string {
+	return strings.TrimSuffix(path, keyFileSuffix) + longKeySuffix
+}
+
+// key文件对应的过期时间文件
+func expiryPath(path string) string {
+	return strings.TrimSuffix(path, keyFileSuffix) + expirySuffix
+}
@@ -0,0 +1,94 @@
+package zzkv
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const DefaultNegativeCacheTTL = time.Second
+
+// 负缓存统计
+type NegativeCacheStats struct {
+	Hits    int64 // 直接由负缓存判定不存在的次数
+	Entries int   // 当前记录的不存在的key数
+}
+
+type negativeEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+// 记录最近确认不存在的key，容量有上限，超出时淘汰最久未访问的
+// 写入该key时失效，超过ttl后重新确认
+type negativeCache struct {
+	maxEntries int
+	ttl        time.Duration
+	items      map[string]*list.Element
+	order      *list.List // 头部为最近访问
+	hits       int64
+	sync.Mutex
+}
+
+func newNegativeCache(maxEntries int, ttl time.Duration) *negativeCache {
+	return &negativeCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// key是否在有效期内被确认过不存在
+func (c *negativeCache) contains(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(element.Value.(*negativeEntry).expireAt) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return false
+	}
+	c.order.MoveToFront(element)
+	c.hits++
+	return true
+}
+
+func (c *negativeCache) add(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	expireAt := time.Now().Add(c.ttl)
+	if element, ok := c.items[key]; ok {
+		element.Value.(*negativeEntry).expireAt = expireAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&negativeEntry{key: key, expireAt: expireAt})
+	if len(c.items) > c.maxEntries {
+		oldest := c.order.Remove(c.order.Back()).(*negativeEntry)
+		delete(c.items, oldest.key)
+	}
+}
+
+func (c *negativeCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+}
+
+func (c *negativeCache) stats() NegativeCacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	return NegativeCacheStats{Hits: c.hits, Entries: len(c.items)}
+}
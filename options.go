@@ -0,0 +1,105 @@
+package zzkv
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	DefaultDataDir             = "zzkv_data"
+	DefaultDirMode os.FileMode = 0755
+)
+
+// 持久化存储后端
+type Backend int
+
+const (
+	FileBackend    Backend = iota // 每个key一个文件
+	BitcaskBackend                // bitcask日志结构存储
+)
+
+// 缓存策略
+type CachePolicy int
+
+const (
+	MapCache     CachePolicy = iota // 不限容量，读过的value全部留在内存
+	LRUCache                        // 按条目数和字节数限制容量，淘汰最久未访问的条目
+	ARCCache                        // 分片的ARC，抗扫描
+	TinyLFUCache                    // 分片的W-TinyLFU，抗扫描
+)
+
+// 配置项
+type Options struct {
+	DataDir  string      // 数据目录，所有数据文件都写在该目录下
+	FileMode os.FileMode // 新建数据文件的权限
+	Backend  Backend     // 持久化存储后端
+
+	// 缓存策略及容量，容量为0表示该项不限制，ARC和W-TinyLFU未设置条目上限时使用DefaultCacheMaxEntries
+	// 有容量限制且未开启回写时，sync为false写入的value被淘汰后该key就读不到了，之前持久化的旧值也不会再返回
+	Cache           CachePolicy
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+	CacheShards     int // ARC和W-TinyLFU的分片数，0表示DefaultCacheShards，字节上限平分到各分片，超过单个分片上限的value不缓存
+
+	// 回写模式，sync为false写入的value由后台协程每隔FlushInterval或脏数据达到FlushBytes时持久化
+	// Flush和Close会写完所有脏数据，回写失败的key保留到下次重试，并调用OnFlushError
+	WriteBack     bool
+	FlushInterval time.Duration
+	FlushBytes    int64
+	OnFlushError  func(key string, err error)
+
+	// 负缓存，记录最多NegativeCacheEntries个最近确认不存在的key，NegativeCacheTTL内再次查询直接返回ErrNotFound
+	// 写入该key时失效，NegativeCacheEntries为0表示不开启
+	NegativeCacheEntries int
+	NegativeCacheTTL     time.Duration
+
+	// 后台每隔ClearInterval删除一次过期key，每批至多ClearBatchSize个，批与批之间不阻塞写入
+	// 过期key在读取时就已不可见，后台删除只为回收空间
+	ClearInterval  time.Duration
+	ClearBatchSize int
+
+	// OnExpire、OnEvict事件队列的容量，队列满时丢弃新事件，0表示DefaultEventQueueSize
+	EventQueueSize int
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		DataDir:  DefaultDataDir,
+		FileMode: DefaultFileMode,
+		Backend:  FileBackend,
+		Cache:    MapCache,
+
+		FlushInterval: DefaultFlushInterval,
+		FlushBytes:    DefaultFlushBytes,
+
+		NegativeCacheTTL: DefaultNegativeCacheTTL,
+
+		ClearInterval:  DefaultClearInterval,
+		ClearBatchSize: DefaultClearBatchSize,
+
+		EventQueueSize: DefaultEventQueueSize,
+	}
+}
+
+// 补齐未设置的配置项，opts为nil时使用默认配置
+func (opts *Options) normalize() *Options {
+	result := DefaultOptions()
+	if opts == nil {
+		return result
+	}
+
+	*result = *opts
+	if result.DataDir == "" {
+		result.DataDir = DefaultDataDir
+	}
+	if result.FileMode == 0 {
+		result.FileMode = DefaultFileMode
+	}
+	if result.FlushInterval <= 0 {
+		result.FlushInterval = DefaultFlushInterval
+	}
+	if result.NegativeCacheTTL <= 0 {
+		result.NegativeCacheTTL = DefaultNegativeCacheTTL
+	}
+	return result
+}
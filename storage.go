@@ -2,28 +2,48 @@ package zzkv
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/zzkv/bitcask"
 )
 
 const DefaultFileMode os.FileMode = 0666
 
 // 持久化存储
 type PersistentStorager interface {
+	// 写入永不过期的value，会清除之前的过期时间
 	Storage(key string, value []byte) error
-	Read(key string) []byte
-	Delete(string)
+	// 写入在expireAt(UnixNano)过期的value，expireAt为0表示永不过期，过期时间与value一起持久化
+	StorageWithExpiry(key string, value []byte, expireAt int64) error
+	// 修改已持久化key的过期时间，expireAt为0表示去掉过期时间，key不存在或已过期时返回ErrNotFound
+	Expire(key string, expireAt int64) error
+	// key不存在时返回ErrNotFound
+	Read(key string) ([]byte, error)
+	Delete(string) error
+	// 已持久化的所有key，顺序不固定
+	Keys() ([]string, error)
+	// 所有设置了过期时间且尚未过期的key及其过期时间(UnixNano)，已过期的key视为不存在
+	Expiries() (map[string]int64, error)
+	// 关闭后其他方法返回ErrClosed
+	Close() error
 }
 
 // 缓存
 type CacheStorager interface {
 	Set(string, []byte) error
-	Get(string) []byte
-	Erase(string)
+	// key不存在时返回ErrNotFound
+	Get(string) ([]byte, error)
+	Erase(string) error
 	IsExist(string) bool
 }
 
@@ -32,23 +52,37 @@ type Storager struct {
 	pstStorager		PersistentStorager
 	cacheStorager 	CacheStorager
 	storageMap 		map[string]bool
+	writeBack 		*writeBack	// 未开启回写时为nil
+	negativeCache 	*negativeCache	// 未开启负缓存时为nil
+	closed 			bool
 	sync.RWMutex
 }
 
+// sync为false时只写缓存，开启回写时由后台协程稍后持久化，否则重启后丢失
 func (s *Storager) Set(key string, val []byte, sync bool) error {
+	return s.SetWithExpiry(key, val, sync, 0)
+}
+
+// 同Set，过期时间expireAt(UnixNano)随value一起持久化，为0表示永不过期
+func (s *Storager) SetWithExpiry(key string, val []byte, sync bool, expireAt int64) error {
 	s.Lock()
 	defer s.Unlock()
-	var setErr error
+	return s.setWithExpiryLocked(key, val, sync, expireAt)
+}
+
+// 同SetWithExpiry，调用方需持有锁
+func (s *Storager) setWithExpiryLocked(key string, val []byte, sync bool, expireAt int64) error {
+	if s.closed {
+		return ErrClosed
+	}
+	var storageErr error
 	setChan := make(chan int8)
 
 	// 开启协程持久化写入
 	go func() {
 		if sync {
-			storageErr := s.pstStorager.Storage(key, val)
-			if storageErr != nil {
-				setErr = storageErr
-			}
-			if _, ok := s.storageMap[key]; !ok {
+			storageErr = s.pstStorager.StorageWithExpiry(key, val, expireAt)
+			if storageErr == nil {
 				s.storageMap[key] = true
 			}
 		}
@@ -58,106 +92,379 @@ func (s *Storager) Set(key string, val []byte, sync bool) error {
 
 	// 写入缓存
 	cacheErr := s.cacheStorager.Set(key, val)
-	if cacheErr != nil {
-		setErr = cacheErr
-	}
 	<-setChan
 
-	return setErr
+	if s.negativeCache != nil {
+		s.negativeCache.remove(key)
+	}
+
+	if s.writeBack != nil {
+		if !sync {
+			s.writeBack.markDirty(key, val, expireAt)
+		} else if storageErr == nil {
+			// 已同步写入更新的值，旧的脏数据不必再回写
+			s.writeBack.clean(key)
+			s.writeBack.conflict(key, &dirtyEntry{value: val, expireAt: expireAt})
+		}
+	} else if !sync {
+		// 新值只在缓存中，持久化存储中的旧值已过时，缓存淘汰后不能再读到旧值
+		delete(s.storageMap, key)
+	}
+
+	if storageErr != nil {
+		return storageErr
+	}
+	return cacheErr
 }
 
-func (s *Storager) Get(key string) []byte  {
+func (s *Storager) Get(key string) ([]byte, error)  {
 	s.RLock()
 	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
 
 	// 查看缓存是否命中
-	if s.cacheStorager.IsExist(key) {
-		return s.cacheStorager.Get(key)
+	result, cacheErr := s.cacheStorager.Get(key)
+	if cacheErr == nil {
+		return result, nil
+	}
+
+	// 尚未回写的value可能已被缓存淘汰
+	if s.writeBack != nil {
+		if entry, ok := s.writeBack.dirty[key]; ok {
+			_ = s.cacheStorager.Set(key, entry.value)
+			return entry.value, nil
+		}
+	}
+
+	if s.negativeCache != nil && s.negativeCache.contains(key) {
+		return nil, ErrNotFound
 	}
 
 	// 查看是否存在
 	if _, ok := s.storageMap[key]; !ok {
-		return nil
+		s.markMissing(key)
+		return nil, ErrNotFound
 	}
 
 	// 缓存未命中，从持久化存储器取
-	result := s.pstStorager.Read(key)
+	result, readErr := s.pstStorager.Read(key)
+	if errors.Is(readErr, ErrNotFound) {
+		s.markMissing(key)
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
 
 	// 写缓存
 	_ = s.cacheStorager.Set(key, result)
 
-	return result
+	return result, nil
 }
 
-func (s *Storager) Erase(key string) {
+// key是否存在，不读取value
+func (s *Storager) Exists(key string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return false, ErrClosed
+	}
+
+	if s.cacheStorager.IsExist(key) {
+		return true, nil
+	}
+	if s.writeBack != nil {
+		if _, ok := s.writeBack.dirty[key]; ok {
+			return true, nil
+		}
+	}
+	_, ok := s.storageMap[key]
+	return ok, nil
+}
+
+// 缓存、回写队列、持久化存储中都没有该key时返回ErrNotFound
+func (s *Storager) Erase(key string) error {
 	s.Lock()
 	defer s.Unlock()
+	return s.eraseLocked(key)
+}
 
-	s.pstStorager.Delete(key)
-	s.cacheStorager.Erase(key)
+// 同Erase，调用方需持有锁
+func (s *Storager) eraseLocked(key string) error {
+	if s.closed {
+		return ErrClosed
+	}
 
+	// 先清缓存，持久化删除失败时也不会读到旧值
+	cached := s.cacheStorager.IsExist(key)
+	cacheErr := s.cacheStorager.Erase(key)
+	if cacheErr != nil {
+		return cacheErr
+	}
+	wasDirty := s.writeBack != nil && s.writeBack.clean(key)
+	if s.writeBack != nil {
+		s.writeBack.conflict(key, nil)
+	}
+	deleteErr := s.pstStorager.Delete(key)
+	if errors.Is(deleteErr, ErrNotFound) && (cached || wasDirty) {
+		// 只存在于缓存或回写队列中的key
+		deleteErr = nil
+	}
+	if deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+		return deleteErr
+	}
+
+	// 文件已不存在，之后的Get不应再去读
+	delete(s.storageMap, key)
+	return deleteErr
 }
 
+// 修改key的过期时间，expireAt为0表示去掉过期时间
+// 尚未回写的key在回写时带上新的过期时间，只在缓存中的key没有可持久化的过期时间，key不存在时返回ErrNotFound
+func (s *Storager) SetExpiry(key string, expireAt int64) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.setExpiryLocked(key, expireAt)
+}
+
+// 同SetExpiry，调用方需持有锁
+func (s *Storager) setExpiryLocked(key string, expireAt int64) error {
+	if s.closed {
+		return ErrClosed
+	}
+
+	if s.writeBack != nil && s.writeBack.setExpiry(key, expireAt) {
+		return nil
+	}
+	if _, ok := s.storageMap[key]; ok {
+		return s.pstStorager.Expire(key, expireAt)
+	}
+	if s.cacheStorager.IsExist(key) {
+		return nil
+	}
+	return ErrNotFound
+}
 
+// 持久化存储中记录的过期时间，见PersistentStorager.Expiries
+func (s *Storager) Expiries() (map[string]int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	return s.pstStorager.Expiries()
+}
+
+
+// 每个key一个文件的持久化存储
 type DefaultPstStorager struct {
+	dir 		string
+	fileMode 	os.FileMode
+	dirLock 	*bitcask.DirLock
+	expiries 	map[string]int64	// 设置了过期时间的key，打开时从.ttl文件加载
+	closed 		bool
 	sync.RWMutex
 }
 
+// key经过编码后作为文件名，任意字节串都不会越出数据目录
+func (s *DefaultPstStorager) fileName(key string) string {
+	path, _ := encodeKeyPath(key)
+	return filepath.Join(s.dir, path)
+}
+
 func (s *DefaultPstStorager) Storage(key string, value []byte) error {
+	return s.StorageWithExpiry(key, value, 0)
+}
+
+// 过期时间写入同名的.ttl文件
+// 先写过期时间再写value，中途崩溃时旧value可能提前过期，但不会留下永不过期的新value
+func (s *DefaultPstStorager) StorageWithExpiry(key string, value []byte, expireAt int64) error {
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	path, hashed := encodeKeyPath(key)
+	fileName := filepath.Join(s.dir, path)
+	mkdirErr := os.MkdirAll(filepath.Dir(fileName), DefaultDirMode)
+	if mkdirErr != nil {
+		return mkdirErr
+	}
+	// 哈希文件名无法还原key，原始key另存一份
+	if hashed {
+		keyErr := writeFileAtomic(longKeyPath(fileName), []byte(key), s.fileMode)
+		if keyErr != nil {
+			return keyErr
+		}
+	}
+	if expireAt != 0 {
+		expiryErr := s.writeExpiry(key, fileName, expireAt)
+		if expiryErr != nil {
+			return expiryErr
+		}
+	}
+
+	writeErr := writeFileAtomic(fileName, value, s.fileMode)
+	if writeErr != nil {
+		return writeErr
+	}
+	// 不带过期时间的写入清除之前的过期时间
+	if expireAt == 0 {
+		return s.removeExpiry(key, fileName)
+	}
+	return nil
+}
+
+// 修改已存在key的过期时间，只改写.ttl文件，expireAt为0表示去掉过期时间
+// key不存在或已过期时返回ErrNotFound
+func (s *DefaultPstStorager) Expire(key string, expireAt int64) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.isExpired(key, time.Now().UnixNano()) {
+		return ErrNotFound
+	}
+
+	fileName := s.fileName(key)
+	_, statErr := os.Stat(fileName)
+	if os.IsNotExist(statErr) {
+		return ErrNotFound
+	}
+	if statErr != nil {
+		return statErr
+	}
+	if expireAt == 0 {
+		return s.removeExpiry(key, fileName)
+	}
+	return s.writeExpiry(key, fileName, expireAt)
+}
+
+// 写入key文件fileName对应的.ttl文件，调用方需持有锁
+func (s *DefaultPstStorager) writeExpiry(key string, fileName string, expireAt int64) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(expireAt))
+	writeErr := writeFileAtomic(expiryPath(fileName), data, s.fileMode)
+	if writeErr != nil {
+		return writeErr
+	}
+	s.expiries[key] = expireAt
+	return nil
+}
+
+// 删除key文件fileName对应的.ttl文件，没有过期时间时不做任何操作，调用方需持有锁
+func (s *DefaultPstStorager) removeExpiry(key string, fileName string) error {
+	if _, ok := s.expiries[key]; !ok {
+		return nil
+	}
+	removeErr := os.Remove(expiryPath(fileName))
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		return removeErr
+	}
+	delete(s.expiries, key)
+	return syncDir(filepath.Dir(fileName))
+}
 
-	fileName := fmt.Sprintf("%s.zzkv", key)
-	// 打开目标文件，不存在则创建, TRUNC标志表示清空之后再写
-	fileHandle, openErr := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, DefaultFileMode)
+// now时刻key是否已过期，调用方需持有锁
+func (s *DefaultPstStorager) isExpired(key string, now int64) bool {
+	expireAt, ok := s.expiries[key]
+	return ok && now >= expireAt
+}
+
+// 先写临时文件并sync，再rename覆盖目标文件，最后sync目录
+// 任何时刻崩溃，目标文件要么是旧内容要么是新内容，不会只写了一半
+func writeFileAtomic(fileName string, data []byte, fileMode os.FileMode) error {
+	tmpName := fileName + tmpFileSuffix
+	// 打开临时文件，不存在则创建, TRUNC标志清掉上次崩溃残留的内容
+	fileHandle, openErr := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
 	if openErr != nil {
 		return openErr
 	}
 
 	// 将value写入文件
-	_, writeErr := io.Copy(io.Writer(fileHandle), bytes.NewReader(value))
+	_, writeErr := io.Copy(io.Writer(fileHandle), bytes.NewReader(data))
 	if writeErr != nil {
+		_ = fileHandle.Close()
+		_ = os.Remove(tmpName)
 		return writeErr
 	}
 	// 同步入磁盘
 	syncErr := fileHandle.Sync()
+	_ = fileHandle.Close()
 	if syncErr != nil {
+		_ = os.Remove(tmpName)
 		return syncErr
 	}
 
-	_ = fileHandle.Close()
-	return nil
+	renameErr := os.Rename(tmpName, fileName)
+	if renameErr != nil {
+		_ = os.Remove(tmpName)
+		return renameErr
+	}
+	return syncDir(filepath.Dir(fileName))
 }
 
-func (s *DefaultPstStorager) Read(key string) []byte {
+func (s *DefaultPstStorager) Read(key string) ([]byte, error) {
 	s.RLock()
 	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	if s.isExpired(key, time.Now().UnixNano()) {
+		return nil, ErrNotFound
+	}
 
-	fileName := fmt.Sprintf("%s.zzkv", key)
+	fileName := s.fileName(key)
 	fileHandle, openErr := os.OpenFile(fileName, os.O_RDONLY, DefaultFileMode)
+	if os.IsNotExist(openErr) {
+		return nil, ErrNotFound
+	}
 	if openErr != nil {
-		panic(fmt.Sprintf("Occur fatal error while opening file. errMsg[%s]", openErr))
+		return nil, openErr
 	}
+	defer fileHandle.Close()
 
 	//从文件中读取value
-	result, readErr := ioutil.ReadAll(fileHandle)
-	if readErr != nil {
-		panic(fmt.Sprintf("Occur fatal error while read file. errMsg[%s]", readErr))
-	}
-	_ = fileHandle.Close()
-	return result
+	return ioutil.ReadAll(fileHandle)
 }
 
-func (s *DefaultPstStorager) Delete(key string)  {
+// 删除key对应的文件并sync所在目录，保证删除在掉电后依然生效
+// key不存在时不做任何操作，返回ErrNotFound
+func (s *DefaultPstStorager) Delete(key string) error {
 	s.Lock()
 	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
 
-	fileName := fmt.Sprintf("%s.zzkv", key)
-	cmd := exec.Command(fmt.Sprintf("rm %s", fileName))
-	_, outErr := cmd.Output()
-	if outErr != nil {
-		panic(outErr)
+	path, hashed := encodeKeyPath(key)
+	fileName := filepath.Join(s.dir, path)
+	removeErr := os.Remove(fileName)
+	if os.IsNotExist(removeErr) {
+		return ErrNotFound
+	}
+	if removeErr != nil {
+		return removeErr
 	}
+	if hashed {
+		keyErr := os.Remove(longKeyPath(fileName))
+		if keyErr != nil && !os.IsNotExist(keyErr) {
+			return keyErr
+		}
+	}
+	if _, ok := s.expiries[key]; ok {
+		expiryErr := os.Remove(expiryPath(fileName))
+		if expiryErr != nil && !os.IsNotExist(expiryErr) {
+			return expiryErr
+		}
+		delete(s.expiries, key)
+	}
+
+	return syncDir(filepath.Dir(fileName))
 }
 
 
@@ -179,9 +486,12 @@ func (s *DefaultCacheStorager) Set(key string, value []byte) (err error) {
 	return
 }
 
-func (s *DefaultCacheStorager) Get(key string) []byte {
-	val, _ := s.Load(key)
-	return val.([]byte)
+func (s *DefaultCacheStorager) Get(key string) ([]byte, error) {
+	val, ok := s.Load(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return val.([]byte), nil
 }
 
 func (s *DefaultCacheStorager) IsExist(key string) bool {
@@ -189,17 +499,227 @@ func (s *DefaultCacheStorager) IsExist(key string) bool {
 	return existOk
 }
 
-func (s *DefaultCacheStorager) Erase(key string)  {
+func (s *DefaultCacheStorager) Erase(key string) error {
 	s.Delete(key)
+	return nil
+}
+
+
+
+
+// 将旧版直接以key命名的文件(<key>.zzkv)迁移到编码后的路径，返回迁移的key数量
+// bitcask的数据文件和hint文件与旧版key文件同名后缀，保持不动
+func (s *DefaultPstStorager) MigrateLegacyKeys() (migrated int, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+
+	entries, readErr := ioutil.ReadDir(s.dir)
+	if readErr != nil {
+		return 0, readErr
+	}
+
+	// 改名涉及的目录，迁移结束后由深到浅sync，崩溃时不会丢失已迁移的文件
+	dirs := map[string]bool{s.dir: true}
+	defer func() {
+		sorted := make([]string, 0, len(dirs))
+		for dir := range dirs {
+			sorted = append(sorted, dir)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return len(sorted[i]) > len(sorted[j])
+		})
+		for _, dir := range sorted {
+			syncErr := syncDir(dir)
+			if syncErr != nil && err == nil {
+				err = syncErr
+			}
+		}
+	}()
+
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || !strings.HasSuffix(entry.Name(), keyFileSuffix) || bitcask.IsDataFile(entry.Name()) {
+			continue
+		}
+
+		key := strings.TrimSuffix(entry.Name(), keyFileSuffix)
+		path, hashed := encodeKeyPath(key)
+		target := filepath.Join(s.dir, path)
+		mkdirErr := os.MkdirAll(filepath.Dir(target), DefaultDirMode)
+		if mkdirErr != nil {
+			return migrated, mkdirErr
+		}
+		for dir := filepath.Dir(target); len(dir) > len(s.dir); dir = filepath.Dir(dir) {
+			dirs[dir] = true
+		}
+		if hashed {
+			keyErr := writeFileAtomic(longKeyPath(target), []byte(key), s.fileMode)
+			if keyErr != nil {
+				return migrated, keyErr
+			}
+		}
+		renameErr := os.Rename(filepath.Join(s.dir, entry.Name()), target)
+		if renameErr != nil {
+			return migrated, renameErr
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// 遍历数据目录，从文件名还原出所有未过期的key
+func (s *DefaultPstStorager) Keys() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0)
+	walkErr := s.walkKeys(keyFileSuffix, func(key string, fileName string) error {
+		if !s.isExpired(key, now) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	return keys, nil
+}
+
+// 遍历数据目录下以suffix结尾的文件，fn的fileName为对应的key文件
+func (s *DefaultPstStorager) walkKeys(suffix string, fn func(key string, fileName string) error) error {
+	return filepath.Walk(s.dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !strings.HasSuffix(fileName, suffix) {
+			return nil
+		}
+		fileName = strings.TrimSuffix(fileName, suffix) + keyFileSuffix
+		path, relErr := filepath.Rel(s.dir, fileName)
+		if relErr != nil {
+			return relErr
+		}
+
+		key, hashed, ok := decodeKeyPath(path)
+		if !ok {
+			return nil
+		}
+		if hashed {
+			data, readErr := ioutil.ReadFile(longKeyPath(fileName))
+			if readErr != nil {
+				return readErr
+			}
+			key = string(data)
+		}
+		return fn(key, fileName)
+	})
+}
+
+func (s *DefaultPstStorager) Expiries() (map[string]int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now().UnixNano()
+	result := make(map[string]int64, len(s.expiries))
+	for key, expireAt := range s.expiries {
+		if now < expireAt {
+			result[key] = expireAt
+		}
+	}
+	return result, nil
 }
 
+// 从.ttl文件加载过期时间，停机期间已过期的key直接删除
+func (s *DefaultPstStorager) loadExpiries() error {
+	now := time.Now().UnixNano()
+	expired := make([]string, 0)
+	walkErr := s.walkKeys(expirySuffix, func(key string, fileName string) error {
+		data, readErr := ioutil.ReadFile(expiryPath(fileName))
+		if readErr != nil {
+			return readErr
+		}
+		if len(data) != 8 {
+			return fmt.Errorf("%w: invalid expiry file %s", ErrCorrupt, expiryPath(fileName))
+		}
+		s.expiries[key] = int64(binary.BigEndian.Uint64(data))
+		if s.isExpired(key, now) {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return walkErr
+	}
 
+	for _, key := range expired {
+		deleteErr := s.Delete(key)
+		if deleteErr != nil && !errors.Is(deleteErr, ErrNotFound) {
+			return deleteErr
+		}
+		// value文件已不在时也要清掉残留的过期时间
+		delete(s.expiries, key)
+		_ = os.Remove(expiryPath(s.fileName(key)))
+	}
+	return nil
+}
 
+// sync目录，使目录项的增删落盘
+func syncDir(dir string) error {
+	dirHandle, openErr := os.Open(dir)
+	if openErr != nil {
+		return openErr
+	}
+	defer dirHandle.Close()
 
-func NewDefaultPstStorager() *DefaultPstStorager {
-	return &DefaultPstStorager{
-		sync.RWMutex{},
+	return dirHandle.Sync()
+}
+
+// 释放数据目录锁
+func (s *DefaultPstStorager) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	s.closed = true
+	return s.dirLock.Unlock()
+}
+
+// 在配置的数据目录下存储，并锁定该目录防止多个进程同时写
+func NewDefaultPstStorager(opts *Options) (*DefaultPstStorager, error) {
+	opts = opts.normalize()
+	mkdirErr := os.MkdirAll(opts.DataDir, DefaultDirMode)
+	if mkdirErr != nil {
+		return nil, mkdirErr
 	}
+	dirLock, lockErr := bitcask.LockDir(opts.DataDir, false)
+	if lockErr != nil {
+		return nil, lockErr
+	}
+
+	result := &DefaultPstStorager{
+		dir:      opts.DataDir,
+		fileMode: opts.FileMode,
+		dirLock:  dirLock,
+		expiries: make(map[string]int64),
+	}
+	loadErr := result.loadExpiries()
+	if loadErr != nil {
+		_ = dirLock.Unlock()
+		return nil, loadErr
+	}
+	return result, nil
 }
 
 func NewDefaultCacheStorager() *DefaultCacheStorager {
@@ -209,12 +729,110 @@ func NewDefaultCacheStorager() *DefaultCacheStorager {
 }
 
 
-func NewDefaultStorager() *Storager {
-	return &Storager{
-		pstStorager:NewDefaultPstStorager(),
-		cacheStorager:NewDefaultCacheStorager(),
-		storageMap:make(map[string]bool),
+// 记入负缓存，之后一段时间内的查询不再访问持久化存储
+func (s *Storager) markMissing(key string) {
+	if s.negativeCache != nil {
+		s.negativeCache.add(key)
+	}
+}
+
+// 负缓存统计，未开启负缓存时返回零值
+func (s *Storager) NegativeCacheStats() NegativeCacheStats {
+	if s.negativeCache == nil {
+		return NegativeCacheStats{}
+	}
+	return s.negativeCache.stats()
+}
+
+// 停止后台回写，写完所有脏数据后关闭持久化存储
+func (s *Storager) Close() error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.Unlock()
+
+	if s.writeBack != nil {
+		close(s.writeBack.stopChan)
+		s.writeBack.wg.Wait()
+	}
+
+	failures := s.flush()
+	s.Lock()
+	closeErr := s.pstStorager.Close()
+	s.Unlock()
+
+	flushErr := s.reportFlushErrors(failures)
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// 缓存统计，缓存不支持统计时返回零值
+func (s *Storager) CacheStats() CacheStats {
+	if statser, ok := s.cacheStorager.(interface{ Stats() CacheStats }); ok {
+		return statser.Stats()
+	}
+	return CacheStats{}
+}
+
+// 按配置的缓存策略创建缓存
+func NewCacheStorager(opts *Options) CacheStorager {
+	opts = opts.normalize()
+	switch opts.Cache {
+	case LRUCache:
+		return NewLRUCacheStorager(opts.CacheMaxEntries, opts.CacheMaxBytes)
+	case ARCCache:
+		return NewARCCacheStorager(opts.CacheMaxEntries, opts.CacheMaxBytes, opts.CacheShards)
+	case TinyLFUCache:
+		return NewTinyLFUCacheStorager(opts.CacheMaxEntries, opts.CacheMaxBytes, opts.CacheShards)
+	}
+	return NewDefaultCacheStorager()
+}
+
+// 按配置的后端创建持久化存储
+func NewPstStorager(opts *Options) (PersistentStorager, error) {
+	opts = opts.normalize()
+	if opts.Backend == BitcaskBackend {
+		return NewBitcaskPstStorager(opts)
+	}
+	return NewDefaultPstStorager(opts)
+}
+
+func NewDefaultStorager(opts *Options) (*Storager, error) {
+	opts = opts.normalize()
+	pstStorager, pstErr := NewPstStorager(opts)
+	if pstErr != nil {
+		return nil, pstErr
+	}
+
+	// 之前写入的key需要能读到，启动时从持久化存储恢复
+	keys, keysErr := pstStorager.Keys()
+	if keysErr != nil {
+		_ = pstStorager.Close()
+		return nil, keysErr
+	}
+	storageMap := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		storageMap[key] = true
+	}
+
+	result := &Storager{
+		pstStorager:pstStorager,
+		cacheStorager:NewCacheStorager(opts),
+		storageMap:storageMap,
+	}
+	if opts.NegativeCacheEntries > 0 {
+		result.negativeCache = newNegativeCache(opts.NegativeCacheEntries, opts.NegativeCacheTTL)
+	}
+	if opts.WriteBack {
+		result.writeBack = newWriteBack(opts)
+		result.runFlusher(opts.FlushInterval)
 	}
+	return result, nil
 }
 
 
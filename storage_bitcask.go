@@ -0,0 +1,60 @@
+package zzkv
+
+import (
+	"github.com/zzkv/bitcask"
+)
+
+// 基于bitcask的持久化存储，适合大量key
+type BitcaskPstStorager struct {
+	db *bitcask.DB
+}
+
+func (s *BitcaskPstStorager) Storage(key string, value []byte) error {
+	return convertBitcaskError(s.db.Put(key, value))
+}
+
+func (s *BitcaskPstStorager) StorageWithExpiry(key string, value []byte, expireAt int64) error {
+	return convertBitcaskError(s.db.PutWithExpiry(key, value, expireAt))
+}
+
+func (s *BitcaskPstStorager) Expire(key string, expireAt int64) error {
+	return convertBitcaskError(s.db.Expire(key, expireAt))
+}
+
+func (s *BitcaskPstStorager) Read(key string) ([]byte, error) {
+	result, getErr := s.db.Get(key)
+	if getErr != nil {
+		return nil, convertBitcaskError(getErr)
+	}
+	return result, nil
+}
+
+func (s *BitcaskPstStorager) Delete(key string) error {
+	return convertBitcaskError(s.db.Delete(key))
+}
+
+func (s *BitcaskPstStorager) Keys() ([]string, error) {
+	keys, keysErr := s.db.Keys()
+	return keys, convertBitcaskError(keysErr)
+}
+
+func (s *BitcaskPstStorager) Expiries() (map[string]int64, error) {
+	expiries, expiriesErr := s.db.Expiries()
+	return expiries, convertBitcaskError(expiriesErr)
+}
+
+func (s *BitcaskPstStorager) Close() error {
+	return convertBitcaskError(s.db.Close())
+}
+
+func NewBitcaskPstStorager(opts *Options) (*BitcaskPstStorager, error) {
+	opts = opts.normalize()
+	bitcaskOpts := bitcask.DefaultOptions()
+	bitcaskOpts.FileMode = opts.FileMode
+
+	db, openErr := bitcask.OpenWithOptions(opts.DataDir, bitcaskOpts)
+	if openErr != nil {
+		return nil, openErr
+	}
+	return &BitcaskPstStorager{db: db}, nil
+}
@@ -0,0 +1,154 @@
+//go:build !windows
+// +build !windows
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/zzkv/bitcask"
+)
+
+const crashDirEnv = "ZZKV_CRASH_DIR"
+
+// 子进程中运行fn后直接被SIGKILL杀掉，不会执行任何清理
+func runAndKill(t *testing.T, testName string, dir string, fn func(dir string)) {
+	if crashDir := os.Getenv(crashDirEnv); crashDir != "" {
+		fn(crashDir)
+		_ = syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		select {}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^"+testName+"$")
+	cmd.Env = append(os.Environ(), crashDirEnv+"="+dir)
+	runErr := cmd.Run()
+	exitErr, ok := runErr.(*exec.ExitError)
+	if !ok {
+		t.Fatal(fmt.Sprintf("child process not killed. errMsg[%v]", runErr))
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() || status.Signal() != syscall.SIGKILL {
+		t.Fatal(fmt.Sprintf("child process exited unexpectedly. errMsg[%v]", runErr))
+	}
+}
+
+func crashOptions() *bitcask.Options {
+	opts := bitcask.DefaultOptions()
+	opts.SyncWrites = false
+	return opts
+}
+
+// 删除标记已写入但尚未sync时进程被杀，重启后key仍然是删除状态
+func TestBitcaskCrashAfterDelete(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	runAndKill(t, "TestBitcaskCrashAfterDelete", dir, func(dir string) {
+		db, _ := bitcask.OpenWithOptions(dir, crashOptions())
+		_ = db.Put("nba", []byte("bitcher zzkv渣渣键值对"))
+		_ = db.Put("cba", []byte("fucker说什么"))
+		_ = db.Sync()
+		_ = db.Delete("nba")
+	})
+
+	db, err := bitcask.OpenWithOptions(dir, crashOptions())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	_, getErr := db.Get("nba")
+	if getErr != bitcask.ErrKeyNotFound {
+		t.Fatal(fmt.Sprintf("deleted key came back after crash. errMsg[%v]", getErr))
+	}
+	fetchVal, getErr := db.Get("cba")
+	if getErr != nil || string(fetchVal) != "fucker说什么" {
+		t.Fatal(fmt.Sprintf("Inconsistent access data after crash. fetch value:%s", string(fetchVal)))
+	}
+
+	t.Log("---------------Test BitcaskCrashAfterDelete PASS------------------")
+}
+
+// 删除标记只写了一部分时进程被杀，残缺的标记被截掉，删除未生效
+func TestBitcaskCrashTornDelete(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	runAndKill(t, "TestBitcaskCrashTornDelete", dir, func(dir string) {
+		db, _ := bitcask.OpenWithOptions(dir, crashOptions())
+		_ = db.Put("nba", []byte("bitcher zzkv渣渣键值对"))
+		_ = db.Sync()
+		_ = db.Delete("nba")
+	})
+
+	// 去掉删除标记的最后几个字节，模拟写到一半
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	info, _ := os.Stat(dataFiles[0])
+	_ = os.Truncate(dataFiles[0], info.Size()-2)
+
+	db, err := bitcask.OpenWithOptions(dir, crashOptions())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	if db.Stats().TruncatedBytes != int64(bitcask.RecordHeaderSize+len("nba")-2) {
+		t.Fatal(fmt.Sprintf("torn tombstone not truncated. stats[%+v]", db.Stats()))
+	}
+	fetchVal, getErr := db.Get("nba")
+	if getErr != nil || string(fetchVal) != "bitcher zzkv渣渣键值对" {
+		t.Fatal(fmt.Sprintf("Inconsistent access data after torn delete. fetch value:%s", string(fetchVal)))
+	}
+
+	t.Log("---------------Test BitcaskCrashTornDelete PASS------------------")
+}
+
+// 删除后合并再被杀，重启后key仍然是删除状态
+func TestBitcaskCrashAfterMerge(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	runAndKill(t, "TestBitcaskCrashAfterMerge", dir, func(dir string) {
+		opts := crashOptions()
+		opts.MaxFileSize = 256
+		opts.MergeRatio = 0
+		db, _ := bitcask.OpenWithOptions(dir, opts)
+		for i := 0; i < 50; i++ {
+			_ = db.Put(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i)))
+		}
+		for i := 0; i < 50; i += 2 {
+			_ = db.Delete(fmt.Sprintf("key_%d", i))
+		}
+		// 让删除标记也进入不可变文件
+		for i := 0; i < 20; i++ {
+			_ = db.Put("padding", []byte(fmt.Sprintf("padding_%d", i)))
+		}
+		_ = db.Merge()
+	})
+
+	db, err := bitcask.OpenWithOptions(dir, crashOptions())
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	if db.Stats().Keys != 26 {
+		t.Fatal(fmt.Sprintf("unexpected keys after merge and crash. stats[%+v]", db.Stats()))
+	}
+	for i := 0; i < 50; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if i%2 == 0 && getErr != bitcask.ErrKeyNotFound {
+			t.Fatal(fmt.Sprintf("deleted key came back after merge. index:%d, errMsg[%v]", i, getErr))
+		}
+		if i%2 == 1 && (getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i)) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after merge. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test BitcaskCrashAfterMerge PASS------------------")
+}
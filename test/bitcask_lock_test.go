@@ -0,0 +1,65 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/zzkv/bitcask"
+)
+
+func TestBitcaskLock(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	readOnly := bitcask.DefaultOptions()
+	readOnly.ReadOnly = true
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	_ = db.Put("nba", []byte("bitcher zzkv渣渣键值对"))
+
+	// 读写打开期间，其他读写或只读打开都失败
+	if _, err = bitcask.Open(dir); err != bitcask.ErrLocked {
+		t.Fatal(fmt.Sprintf("second open not rejected. errMsg[%v]", err))
+	}
+	if _, err = bitcask.OpenWithOptions(dir, readOnly); err != bitcask.ErrLocked {
+		t.Fatal(fmt.Sprintf("read-only open not rejected. errMsg[%v]", err))
+	}
+	_ = db.Close()
+
+	// 多个只读打开可以共存
+	reader1, err := bitcask.OpenWithOptions(dir, readOnly)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open read-only bitcask. errMsg[%s]", err))
+	}
+	reader2, err := bitcask.OpenWithOptions(dir, readOnly)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open second read-only bitcask. errMsg[%s]", err))
+	}
+	for _, reader := range []*bitcask.DB{reader1, reader2} {
+		fetchVal, getErr := reader.Get("nba")
+		if getErr != nil || string(fetchVal) != "bitcher zzkv渣渣键值对" {
+			t.Fatal(fmt.Sprintf("Inconsistent access data in read-only mode. fetch value:%s", string(fetchVal)))
+		}
+		if putErr := reader.Put("nba", []byte("fucker说什么")); putErr != bitcask.ErrReadOnly {
+			t.Fatal(fmt.Sprintf("write not rejected in read-only mode. errMsg[%v]", putErr))
+		}
+	}
+	if _, err = bitcask.Open(dir); err != bitcask.ErrLocked {
+		t.Fatal(fmt.Sprintf("read-write open not rejected while readers exist. errMsg[%v]", err))
+	}
+	_ = reader1.Close()
+	_ = reader2.Close()
+
+	// 全部关闭后可以再次读写打开
+	db, err = bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask after unlock. errMsg[%s]", err))
+	}
+	_ = db.Close()
+
+	t.Log("---------------Test BitcaskLock PASS------------------")
+}
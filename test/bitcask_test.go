@@ -0,0 +1,674 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zzkv/bitcask"
+)
+
+func newBitcaskDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zzkv_bitcask")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create temp dir. errMsg[%s]", err))
+	}
+	return dir
+}
+
+func TestBitcask(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
+	for i, val := range values {
+		putErr := db.Put(fmt.Sprintf("key_%d", i), []byte(val))
+		if putErr != nil {
+			t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+		}
+	}
+
+	for i, val := range values {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil {
+			t.Fatal(fmt.Sprintf("failed to get. errMsg[%s]", getErr))
+		}
+		if string(fetchVal) != val {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	// 覆盖写
+	putErr := db.Put("key_0", []byte(values[3]))
+	if putErr != nil {
+		t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+	}
+	fetchVal, getErr := db.Get("key_0")
+	if getErr != nil || string(fetchVal) != values[3] {
+		t.Fatal(fmt.Sprintf("Inconsistent access data after overwrite. fetch value:%s", string(fetchVal)))
+	}
+
+	// 删除
+	delErr := db.Delete("key_1")
+	if delErr != nil {
+		t.Fatal(fmt.Sprintf("failed to delete. errMsg[%s]", delErr))
+	}
+	_, getErr = db.Get("key_1")
+	if getErr != bitcask.ErrKeyNotFound {
+		t.Fatal(fmt.Sprintf("deleted key still readable. errMsg[%v]", getErr))
+	}
+
+	// 重启后删除依然有效
+	_ = db.Close()
+	db, err = bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	_, getErr = db.Get("key_1")
+	if getErr != bitcask.ErrKeyNotFound {
+		t.Fatal(fmt.Sprintf("deleted key came back after restart. errMsg[%v]", getErr))
+	}
+
+	t.Log("---------------Test Bitcask PASS------------------")
+}
+
+func TestBitcaskChecksum(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	putErr := db.Put("nba", []byte("bitcher zzkv渣渣键值对"))
+	if putErr != nil {
+		t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+	}
+
+	// 篡改value的最后一个字节
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	if len(dataFiles) != 1 {
+		t.Fatal(fmt.Sprintf("unexpected data files. files[%v]", dataFiles))
+	}
+	data, _ := ioutil.ReadFile(dataFiles[0])
+	data[len(data)-1] ^= 0xff
+	_ = ioutil.WriteFile(dataFiles[0], data, bitcask.DefaultFileMode)
+
+	_, getErr := db.Get("nba")
+	if _, ok := getErr.(*bitcask.ChecksumError); !ok {
+		t.Fatal(fmt.Sprintf("corrupted record not detected. errMsg[%v]", getErr))
+	}
+
+	t.Log("---------------Test BitcaskChecksum PASS------------------")
+}
+
+func TestBitcaskRecovery(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
+	for i, val := range values {
+		putErr := db.Put(fmt.Sprintf("key_%d", i%2), []byte(val))
+		if putErr != nil {
+			t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+		}
+	}
+	_ = db.Close()
+
+	// 模拟崩溃时写了一半的记录
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	fileHandle, _ := os.OpenFile(dataFiles[0], os.O_WRONLY|os.O_APPEND, bitcask.DefaultFileMode)
+	tornTail := make([]byte, bitcask.RecordHeaderSize+3)
+	tornTail[15] = 10
+	_, _ = fileHandle.Write(tornTail)
+	_ = fileHandle.Close()
+
+	db, err = bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	if db.Stats().TruncatedBytes != int64(len(tornTail)) {
+		t.Fatal(fmt.Sprintf("unexpected truncated bytes. truncated[%d]", db.Stats().TruncatedBytes))
+	}
+	for i := 0; i < 2; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != values[i+2] {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after recovery. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	// 截断后继续写入不受影响
+	putErr := db.Put("key_2", []byte(values[0]))
+	if putErr != nil {
+		t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+	}
+	fetchVal, getErr := db.Get("key_2")
+	if getErr != nil || string(fetchVal) != values[0] {
+		t.Fatal(fmt.Sprintf("Inconsistent access data after truncation. fetch value:%s", string(fetchVal)))
+	}
+
+	t.Log("---------------Test BitcaskRecovery PASS------------------")
+}
+
+// 文件中间或不可变文件中的损坏不能当作残缺尾部截掉，打开时报错且不修改文件
+func TestBitcaskRecoveryCorruption(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	for i := 0; i < 5; i++ {
+		_ = db.Put(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	_ = db.Close()
+
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	original, _ := ioutil.ReadFile(dataFiles[0])
+	recordSize := bitcask.RecordHeaderSize + len("k0") + len("value_0")
+	// 第2条记录的keySize、value分别被篡改
+	for _, index := range []int{recordSize*2 + 13, recordSize*2 + recordSize - 1} {
+		data := append([]byte(nil), original...)
+		data[index] ^= 0xff
+		_ = ioutil.WriteFile(dataFiles[0], data, bitcask.DefaultFileMode)
+
+		_, err = bitcask.Open(dir)
+		if _, ok := err.(*bitcask.ChecksumError); !ok {
+			t.Fatal(fmt.Sprintf("corruption in the middle not reported. index:%d, errMsg[%v]", index, err))
+		}
+		info, _ := os.Stat(dataFiles[0])
+		if info.Size() != int64(len(original)) {
+			t.Fatal(fmt.Sprintf("corrupted file truncated. index:%d, size:%d", index, info.Size()))
+		}
+	}
+	_ = ioutil.WriteFile(dataFiles[0], original, bitcask.DefaultFileMode)
+
+	// 不可变文件的尾部残缺
+	opts := bitcask.DefaultOptions()
+	opts.MaxFileSize = 64
+	db, err = bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	for i := 0; i < 10; i++ {
+		_ = db.Put(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("value_%d", i)))
+	}
+	_ = db.Close()
+	hintFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.hint"))
+	for _, hintFile := range hintFiles {
+		_ = os.Remove(hintFile)
+	}
+	dataFiles, _ = filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	if len(dataFiles) < 2 {
+		t.Fatal(fmt.Sprintf("data files not rotated. files[%v]", dataFiles))
+	}
+	info, _ := os.Stat(dataFiles[0])
+	_ = os.Truncate(dataFiles[0], info.Size()-2)
+
+	_, err = bitcask.OpenWithOptions(dir, opts)
+	if _, ok := err.(*bitcask.ChecksumError); !ok {
+		t.Fatal(fmt.Sprintf("torn immutable file not reported. errMsg[%v]", err))
+	}
+	truncatedInfo, _ := os.Stat(dataFiles[0])
+	if truncatedInfo.Size() != info.Size()-2 {
+		t.Fatal(fmt.Sprintf("immutable file truncated. size:%d", truncatedInfo.Size()))
+	}
+
+	t.Log("---------------Test BitcaskRecoveryCorruption PASS------------------")
+}
+
+// 把当前数据文件改名为历史日期，使其成为不可变文件
+func TestBitcaskRotation(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := bitcask.DefaultOptions()
+	opts.MaxFileSize = 256
+	db, err := bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	for i := 0; i < 100; i++ {
+		putErr := db.Put(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i)))
+		if putErr != nil {
+			t.Fatal(fmt.Sprintf("failed to put. errMsg[%s]", putErr))
+		}
+	}
+	_ = db.Close()
+
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	if len(dataFiles) < 2 {
+		t.Fatal(fmt.Sprintf("data file not rotated. files[%v]", dataFiles))
+	}
+	for _, dataFile := range dataFiles {
+		info, _ := os.Stat(dataFile)
+		if info.Size() > opts.MaxFileSize {
+			t.Fatal(fmt.Sprintf("data file exceeds max size. file[%s] size[%d]", dataFile, info.Size()))
+		}
+	}
+
+	db, err = bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after rotation. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test BitcaskRotation PASS------------------")
+}
+
+func TestBitcaskLegacyDataFile(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	_ = db.Put("nba", []byte("bitcher zzkv渣渣键值对"))
+	_ = db.Close()
+
+	// 改为旧版按日期命名的文件
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	_ = os.Rename(dataFiles[0], filepath.Join(dir, "bitcask_2020- 6- 6.zzkv"))
+
+	db, err = bitcask.Open(dir)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	fetchVal, getErr := db.Get("nba")
+	if getErr != nil || string(fetchVal) != "bitcher zzkv渣渣键值对" {
+		t.Fatal(fmt.Sprintf("Inconsistent access data after migration. fetch value:%s", string(fetchVal)))
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "bitcask_000000001.zzkv")); statErr != nil {
+		t.Fatal(fmt.Sprintf("legacy data file not renamed. errMsg[%s]", statErr))
+	}
+
+	t.Log("---------------Test BitcaskLegacyDataFile PASS------------------")
+}
+
+func TestBitcaskHint(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := bitcask.DefaultOptions()
+	opts.MaxFileSize = 128
+	db, err := bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
+	for i, val := range values {
+		_ = db.Put(fmt.Sprintf("key_%d", i%3), []byte(val))
+	}
+	_ = db.Close()
+
+	// 切换出去的文件都应生成hint，活跃文件没有
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.zzkv"))
+	hintFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.hint"))
+	if len(hintFiles) == 0 || len(hintFiles) != len(dataFiles)-1 {
+		t.Fatal(fmt.Sprintf("hint files not generated. data[%v] hint[%v]", dataFiles, hintFiles))
+	}
+
+	checkValues := func() {
+		db, err = bitcask.OpenWithOptions(dir, opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+		}
+		defer db.Close()
+
+		expected := map[string]string{"key_0": values[3], "key_1": values[1], "key_2": values[2]}
+		for key, val := range expected {
+			fetchVal, getErr := db.Get(key)
+			if getErr != nil || string(fetchVal) != val {
+				t.Fatal(fmt.Sprintf("Inconsistent access data. key:%s, fetch value:%s", key, string(fetchVal)))
+			}
+		}
+	}
+
+	// 从hint加载
+	checkValues()
+
+	// hint损坏时回退为扫描数据文件
+	for _, hintFile := range hintFiles {
+		_ = ioutil.WriteFile(hintFile, []byte("broken hint"), bitcask.DefaultFileMode)
+	}
+	checkValues()
+
+	t.Log("---------------Test BitcaskHint PASS------------------")
+}
+
+// 启动耗时对比的数据量，默认64MB，可通过ZZKV_BENCH_DATASET_MB调整到GB级别
+func bitcaskBenchDatasetMB() int {
+	datasetMB := 64
+	if env := os.Getenv("ZZKV_BENCH_DATASET_MB"); env != "" {
+		_, _ = fmt.Sscanf(env, "%d", &datasetMB)
+	}
+	return datasetMB
+}
+
+func bitcaskBenchOptions() *bitcask.Options {
+	opts := bitcask.DefaultOptions()
+	opts.MaxFileSize = 16 << 20
+	opts.SyncWrites = false
+	return opts
+}
+
+func prepareBitcaskBenchDataset(b *testing.B) string {
+	dir, err := ioutil.TempDir("", "zzkv_bitcask_bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	db, err := bitcask.OpenWithOptions(dir, bitcaskBenchOptions())
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := make([]byte, 4096)
+	count := bitcaskBenchDatasetMB() * 1024 * 1024 / len(value)
+	for i := 0; i < count; i++ {
+		putErr := db.Put(fmt.Sprintf("key_%d", i), value)
+		if putErr != nil {
+			b.Fatal(putErr)
+		}
+	}
+	_ = db.Close()
+	return dir
+}
+
+func benchmarkBitcaskOpen(b *testing.B, withHint bool) {
+	dir := prepareBitcaskBenchDataset(b)
+	defer os.RemoveAll(dir)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if !withHint {
+			b.StopTimer()
+			hintFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.hint"))
+			for _, hintFile := range hintFiles {
+				_ = os.Remove(hintFile)
+			}
+			b.StartTimer()
+		}
+
+		db, err := bitcask.OpenWithOptions(dir, bitcaskBenchOptions())
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		_ = db.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkBitcaskOpenWithHint(b *testing.B) {
+	benchmarkBitcaskOpen(b, true)
+}
+
+func BenchmarkBitcaskOpenWithoutHint(b *testing.B) {
+	benchmarkBitcaskOpen(b, false)
+}
+
+func TestBitcaskMerge(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	// 每个key覆盖写多次，制造死数据
+	opts := &bitcask.Options{MaxFileSize: 1024, SyncWrites: true}
+	db, err := bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			_ = db.Put(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d_%d", i, round)))
+		}
+	}
+	_ = db.Delete("key_0")
+	before := db.Stats()
+
+	// 合并期间并发读
+	stopChan := make(chan bool)
+	readErrChan := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-stopChan:
+				readErrChan <- nil
+				return
+			default:
+			}
+			fetchVal, getErr := db.Get("key_1")
+			if getErr != nil || string(fetchVal) != "value_1_4" {
+				readErrChan <- fmt.Errorf("read during merge failed. value[%s] errMsg[%v]", string(fetchVal), getErr)
+				return
+			}
+		}
+	}()
+
+	mergeErr := db.Merge()
+	close(stopChan)
+	if mergeErr != nil {
+		t.Fatal(fmt.Sprintf("failed to merge. errMsg[%s]", mergeErr))
+	}
+	if readErr := <-readErrChan; readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	after := db.Stats()
+	if after.Merges != 1 || after.ReclaimedBytes <= 0 || after.TotalBytes != before.TotalBytes-after.ReclaimedBytes || after.DataFiles >= before.DataFiles {
+		t.Fatal(fmt.Sprintf("unexpected merge stats. before[%+v] after[%+v]", before, after))
+	}
+	_ = db.Close()
+
+	db, err = bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+	if _, getErr := db.Get("key_0"); getErr != bitcask.ErrKeyNotFound {
+		t.Fatal(fmt.Sprintf("deleted key came back after merge. errMsg[%v]", getErr))
+	}
+	for i := 1; i < 100; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d_4", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after merge. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test BitcaskMerge PASS------------------")
+}
+
+func TestBitcaskAutoMerge(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	db, err := bitcask.OpenWithOptions(dir, &bitcask.Options{MaxFileSize: 1024, SyncWrites: true, MergeRatio: 0.5})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 100; i++ {
+			_ = db.Put(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d_%d", i, round)))
+		}
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for db.Stats().Merges == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal(fmt.Sprintf("merge not triggered. stats[%+v]", db.Stats()))
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	for i := 0; i < 100; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d_4", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after auto merge. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test BitcaskAutoMerge PASS------------------")
+}
+
+// 合并进行中Close，等合并结束后才释放目录，不会留下合并的临时文件
+func TestBitcaskCloseDuringMerge(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := &bitcask.Options{MaxFileSize: 4096}
+	db, err := bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10000; i++ {
+			_ = db.Put(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d_%d", i, round)))
+		}
+	}
+
+	mergeErrChan := make(chan error, 1)
+	go func() {
+		mergeErrChan <- db.Merge()
+	}()
+	// 等合并开始写临时文件
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if mergeFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.merge")); len(mergeFiles) > 0 {
+			break
+		}
+	}
+	closeErr := db.Close()
+	if closeErr != nil {
+		t.Fatal(fmt.Sprintf("failed to close bitcask. errMsg[%s]", closeErr))
+	}
+	if mergeFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.merge")); len(mergeFiles) != 0 {
+		t.Fatal(fmt.Sprintf("merge still running after close. files[%v]", mergeFiles))
+	}
+	if mergeErr := <-mergeErrChan; mergeErr != nil && mergeErr != bitcask.ErrClosed {
+		t.Fatal(fmt.Sprintf("failed to merge. errMsg[%s]", mergeErr))
+	}
+
+	db, err = bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	defer db.Close()
+	for i := 0; i < 10000; i++ {
+		fetchVal, getErr := db.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d_1", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after merge. index:%d, fetch value:%s", i, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test BitcaskCloseDuringMerge PASS------------------")
+}
+
+// 过期时间随记录持久化，经过hint、扫描和合并后依然有效，停机期间过期的key重启后不存在
+func TestBitcaskExpiry(t *testing.T) {
+	dir := newBitcaskDir(t)
+	defer os.RemoveAll(dir)
+
+	opts := bitcask.DefaultOptions()
+	opts.MaxFileSize = 128
+	db, err := bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to open bitcask. errMsg[%s]", err))
+	}
+	liveExpireAt := time.Now().Add(time.Hour).UnixNano()
+	_ = db.PutWithExpiry("live", []byte("fucker说什么"), liveExpireAt)
+	_ = db.PutWithExpiry("short", []byte("bitcher zzkv渣渣键值对"), time.Now().Add(300*time.Millisecond).UnixNano())
+	_ = db.Put("plain", []byte("12345879&……%%我要怎么说--+++!@#$%"))
+	// 切换活跃文件，让带过期时间的记录进入有hint的文件
+	for i := 0; i < 10; i++ {
+		_ = db.Put("padding", []byte(fmt.Sprintf("padding_%d", i)))
+	}
+	_ = db.Close()
+	time.Sleep(400 * time.Millisecond)
+
+	checkExpiry := func() {
+		db, err = bitcask.OpenWithOptions(dir, opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+		}
+		defer db.Close()
+
+		if _, getErr := db.Get("short"); getErr != bitcask.ErrKeyNotFound {
+			t.Fatal(fmt.Sprintf("expired key came back after reopen. errMsg[%v]", getErr))
+		}
+		keys, _ := db.Keys()
+		if len(keys) != 3 {
+			t.Fatal(fmt.Sprintf("unexpected keys after reopen. keys[%v]", keys))
+		}
+		expiries, _ := db.Expiries()
+		if len(expiries) != 1 || expiries["live"] != liveExpireAt {
+			t.Fatal(fmt.Sprintf("expiry lost after reopen. expiries[%v]", expiries))
+		}
+		fetchVal, getErr := db.Get("live")
+		if getErr != nil || string(fetchVal) != "fucker说什么" {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. fetch value:%s", string(fetchVal)))
+		}
+	}
+
+	// 从hint加载
+	checkExpiry()
+
+	// 扫描数据文件
+	hintFiles, _ := filepath.Glob(filepath.Join(dir, "bitcask_*.hint"))
+	if len(hintFiles) == 0 {
+		t.Fatal("hint files not generated.")
+	}
+	for _, hintFile := range hintFiles {
+		_ = os.Remove(hintFile)
+	}
+	checkExpiry()
+
+	// 合并后
+	db, err = bitcask.OpenWithOptions(dir, opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen bitcask. errMsg[%s]", err))
+	}
+	_ = db.PutWithExpiry("merged", []byte("value"), time.Now().Add(100*time.Millisecond).UnixNano())
+	for i := 0; i < 10; i++ {
+		_ = db.Put("padding", []byte(fmt.Sprintf("padding_%d", i)))
+	}
+	time.Sleep(200 * time.Millisecond)
+	mergeErr := db.Merge()
+	_ = db.Close()
+	if mergeErr != nil {
+		t.Fatal(fmt.Sprintf("failed to merge. errMsg[%s]", mergeErr))
+	}
+	checkExpiry()
+
+	t.Log("---------------Test BitcaskExpiry PASS------------------")
+}
@@ -0,0 +1,198 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/zzkv"
+)
+
+type cacheWithStats interface {
+	zzkv.CacheStorager
+	Stats() zzkv.CacheStats
+}
+
+// 各缓存策略，参数为条目上限和字节上限
+var cachePolicies = []struct {
+	name     string
+	newCache func(maxEntries int, maxBytes int64) cacheWithStats
+}{
+	{"LRU", func(maxEntries int, maxBytes int64) cacheWithStats {
+		return zzkv.NewLRUCacheStorager(maxEntries, maxBytes)
+	}},
+	{"ARC", func(maxEntries int, maxBytes int64) cacheWithStats {
+		return zzkv.NewARCCacheStorager(maxEntries, maxBytes, 0)
+	}},
+	{"TinyLFU", func(maxEntries int, maxBytes int64) cacheWithStats {
+		return zzkv.NewTinyLFUCacheStorager(maxEntries, maxBytes, 0)
+	}},
+}
+
+const (
+	traceKeys     = 10000
+	traceRequests = 200000
+	traceScanLen  = 5000 // 每次扫描访问的不重复key数
+	traceScanGap  = 5000 // 两次扫描之间的热点访问数
+)
+
+// 热点访问服从Zipf分布
+func zipfTrace(seed int64) []string {
+	random := rand.New(rand.NewSource(seed))
+	zipf := rand.NewZipf(random, 1.1, 1, traceKeys-1)
+	trace := make([]string, traceRequests)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key_%d", zipf.Uint64())
+	}
+	return trace
+}
+
+// Zipf热点访问中周期性插入一次性的全量扫描，模拟批量导出
+func scanTrace(seed int64) []string {
+	random := rand.New(rand.NewSource(seed))
+	zipf := rand.NewZipf(random, 1.1, 1, traceKeys-1)
+	trace := make([]string, 0, traceRequests)
+	scanned := 0
+	for len(trace) < traceRequests {
+		for i := 0; i < traceScanGap; i++ {
+			trace = append(trace, fmt.Sprintf("key_%d", zipf.Uint64()))
+		}
+		for i := 0; i < traceScanLen; i++ {
+			trace = append(trace, fmt.Sprintf("scan_%d", scanned))
+			scanned++
+		}
+	}
+	return trace[:traceRequests]
+}
+
+// 按访问序列读缓存，未命中时写入，与Storager.Get的用法一致，返回命中率
+func replayTrace(cache cacheWithStats, trace []string, requests int) float64 {
+	value := []byte("bitcher zzkv渣渣键值对")
+	for i := 0; i < requests; i++ {
+		key := trace[i%len(trace)]
+		if _, getErr := cache.Get(key); getErr != nil {
+			_ = cache.Set(key, value)
+		}
+	}
+	stats := cache.Stats()
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+func TestCachePolicies(t *testing.T) {
+	for _, policy := range cachePolicies {
+		cache := policy.newCache(100, 0)
+		setErr := cache.Set("nba", []byte("bitcher zzkv渣渣键值对"))
+		if setErr != nil {
+			t.Fatal(fmt.Sprintf("failed to set. policy[%s] errMsg[%s]", policy.name, setErr))
+		}
+		fetchVal, getErr := cache.Get("nba")
+		if getErr != nil || string(fetchVal) != "bitcher zzkv渣渣键值对" {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. policy[%s] errMsg[%v]", policy.name, getErr))
+		}
+		_ = cache.Set("nba", []byte("fucker说什么"))
+		fetchVal, getErr = cache.Get("nba")
+		if getErr != nil || string(fetchVal) != "fucker说什么" {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after overwrite. policy[%s] errMsg[%v]", policy.name, getErr))
+		}
+		_ = cache.Erase("nba")
+		_, getErr = cache.Get("nba")
+		if !errors.Is(getErr, zzkv.ErrNotFound) || cache.IsExist("nba") {
+			t.Fatal(fmt.Sprintf("erased key still cached. policy[%s] errMsg[%v]", policy.name, getErr))
+		}
+
+		// 条目数和字节数都不超过上限
+		for _, limits := range []struct {
+			maxEntries int
+			maxBytes   int64
+		}{{100, 0}, {0, 1000}} {
+			cache = policy.newCache(limits.maxEntries, limits.maxBytes)
+			_ = replayTrace(cache, zipfTrace(1), 10000)
+			stats := cache.Stats()
+			if limits.maxEntries > 0 && stats.Entries > limits.maxEntries {
+				t.Fatal(fmt.Sprintf("entry limit exceeded. policy[%s] stats[%+v]", policy.name, stats))
+			}
+			if limits.maxBytes > 0 && stats.Bytes > limits.maxBytes {
+				t.Fatal(fmt.Sprintf("byte limit exceeded. policy[%s] stats[%+v]", policy.name, stats))
+			}
+			if stats.Evictions == 0 {
+				t.Fatal(fmt.Sprintf("nothing evicted. policy[%s] stats[%+v]", policy.name, stats))
+			}
+		}
+	}
+
+	t.Log("---------------Test CachePolicies PASS------------------")
+}
+
+// 字节上限小于分片数时，每个分片仍有字节上限，总量不超过上限
+func TestShardedCacheSmallByteLimit(t *testing.T) {
+	for _, policy := range cachePolicies[1:] {
+		cache := policy.newCache(0, 8)
+		for i := 0; i < 100; i++ {
+			_ = cache.Set(fmt.Sprintf("key_%d", i), []byte("v"))
+		}
+		stats := cache.Stats()
+		if stats.Bytes > 8 || stats.Entries == 0 {
+			t.Fatal(fmt.Sprintf("byte limit exceeded. policy[%s] stats[%+v]", policy.name, stats))
+		}
+	}
+
+	t.Log("---------------Test ShardedCacheSmallByteLimit PASS------------------")
+}
+
+// 扫描会冲掉LRU中的热点，ARC和W-TinyLFU的命中率应明显更高
+func TestCacheScanResistance(t *testing.T) {
+	trace := scanTrace(1)
+	ratios := make(map[string]float64)
+	for _, policy := range cachePolicies {
+		ratios[policy.name] = replayTrace(policy.newCache(1000, 0), trace, len(trace))
+	}
+	t.Log(fmt.Sprintf("hit ratios under scan: %v", ratios))
+
+	for _, name := range []string{"ARC", "TinyLFU"} {
+		if ratios[name] <= ratios["LRU"] {
+			t.Fatal(fmt.Sprintf("policy not scan resistant. policy[%s] ratios[%v]", name, ratios))
+		}
+	}
+
+	t.Log("---------------Test CacheScanResistance PASS------------------")
+}
+
+// 每种访问序列下各缓存策略的命中率，以hit-ratio指标输出
+func BenchmarkCachePolicies(b *testing.B) {
+	traces := []struct {
+		name  string
+		trace []string
+	}{{"Zipf", zipfTrace(1)}, {"Scan", scanTrace(1)}}
+
+	for _, trace := range traces {
+		for _, policy := range cachePolicies {
+			b.Run(trace.name+"/"+policy.name, func(b *testing.B) {
+				cache := policy.newCache(1000, 0)
+				b.ResetTimer()
+				hitRatio := replayTrace(cache, trace.trace, b.N)
+				b.ReportMetric(hitRatio, "hit-ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkCachePoliciesParallel(b *testing.B) {
+	trace := zipfTrace(1)
+	for _, policy := range cachePolicies {
+		b.Run(policy.name, func(b *testing.B) {
+			cache := policy.newCache(1000, 0)
+			value := []byte("bitcher zzkv渣渣键值对")
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(len(trace))
+				for pb.Next() {
+					key := trace[i%len(trace)]
+					if _, getErr := cache.Get(key); getErr != nil {
+						_ = cache.Set(key, value)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
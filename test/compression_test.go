@@ -1,25 +1,31 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/zzkv"
 	"testing"
 )
 
 func TestCompression(t *testing.T) {
-	defer func() {
-		err := recover()
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
-
 	data := "12345879&……%%我要怎么说--+++!@#$%"
 	zipComp := zzkv.NewDefaultCompression()
-	compressData := string(zipComp.Compress([]byte(data)))
-	decompressData := string(zipComp.Decompress([]byte(compressData)))
-	t.Log(fmt.Sprintf("compressed: %s", compressData))
-	t.Log(fmt.Sprintf("decompressed: %s", decompressData))
+	compressData, err := zipComp.Compress([]byte(data))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to compress. errMsg[%s]", err))
+	}
+	decompressData, err := zipComp.Decompress(compressData)
+	if err != nil || string(decompressData) != data {
+		t.Fatal(fmt.Sprintf("failed to decompress. errMsg[%v]", err))
+	}
+	t.Log(fmt.Sprintf("compressed: %s", string(compressData)))
+	t.Log(fmt.Sprintf("decompressed: %s", string(decompressData)))
+
+	// 非gzip数据解压失败，返回错误而不是panic
+	_, err = zipComp.Decompress([]byte(data))
+	if !errors.Is(err, zzkv.ErrCorrupt) {
+		t.Fatal(fmt.Sprintf("corrupted data not reported. errMsg[%v]", err))
+	}
 	t.Log("------------Test Compression PASS------------")
 
 }
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zzkv"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrNotFound(t *testing.T) {
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		z1, cleanup := newTestZzkv(t, backend)
+		t2 := &TestStt{}
+		err := z1.Get("missing", t2)
+		if !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("missing key not reported. backend[%d] errMsg[%v]", backend, err))
+		}
+		cleanup()
+	}
+
+	opts := zzkv.DefaultOptions()
+	opts.DataDir = newBitcaskDir(t)
+	defer os.RemoveAll(opts.DataDir)
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		opts.Backend = backend
+		s1, err := zzkv.NewPstStorager(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+		}
+		_, readErr := s1.Read("missing")
+		if !errors.Is(readErr, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("missing key not reported. backend[%d] errMsg[%v]", backend, readErr))
+		}
+		_ = s1.Close()
+	}
+
+	_, getErr := zzkv.NewDefaultCacheStorager().Get("missing")
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("missing key not reported by cache. errMsg[%v]", getErr))
+	}
+
+	t.Log("---------------Test ErrNotFound PASS------------------")
+}
+
+func TestErrCorrupt(t *testing.T) {
+	opts := zzkv.DefaultOptions()
+	opts.DataDir = newBitcaskDir(t)
+	defer os.RemoveAll(opts.DataDir)
+	s1, err := zzkv.NewBitcaskPstStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+
+	setErr := s1.Storage("nba", []byte("bitcher zzkv渣渣键值对"))
+	if setErr != nil {
+		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+	}
+
+	// 改坏value的最后一个字节
+	dataFiles, _ := filepath.Glob(filepath.Join(opts.DataDir, "bitcask_*.zzkv"))
+	dataFile, _ := os.OpenFile(dataFiles[0], os.O_RDWR, 0)
+	info, _ := dataFile.Stat()
+	_, _ = dataFile.WriteAt([]byte{'x'}, info.Size()-1)
+	_ = dataFile.Close()
+
+	_, readErr := s1.Read("nba")
+	if !errors.Is(readErr, zzkv.ErrCorrupt) {
+		t.Fatal(fmt.Sprintf("corrupted record not reported. errMsg[%v]", readErr))
+	}
+
+	t.Log("---------------Test ErrCorrupt PASS------------------")
+}
@@ -0,0 +1,92 @@
+//go:build !windows
+// +build !windows
+
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zzkv"
+)
+
+// 两个足够大的value，写到一半时内容与两者都不相同
+func atomicWriteValues() [][]byte {
+	return [][]byte{bytes.Repeat([]byte("o"), 1<<20), bytes.Repeat([]byte("n"), 2<<20)}
+}
+
+func isAtomicWriteValue(data []byte) bool {
+	for _, val := range atomicWriteValues() {
+		if bytes.Equal(data, val) {
+			return true
+		}
+	}
+	return false
+}
+
+// 子进程不停覆盖同一个key，在任意时刻被SIGKILL杀掉，同时另一个进程直接读数据文件
+func TestPstStoragerCrashDuringWrite(t *testing.T) {
+	values := atomicWriteValues()
+	if crashDir := os.Getenv(crashDirEnv); crashDir != "" {
+		opts := zzkv.DefaultOptions()
+		opts.DataDir = crashDir
+		s, _ := zzkv.NewDefaultPstStorager(opts)
+		for i := 0; ; i++ {
+			_ = s.Storage("nba", values[i%2])
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "zzkv_test")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create temp dir. errMsg[%s]", err))
+	}
+	defer os.RemoveAll(dir)
+
+	for round := 0; round < 5; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestPstStoragerCrashDuringWrite$")
+		cmd.Env = append(os.Environ(), crashDirEnv+"="+dir)
+		startErr := cmd.Start()
+		if startErr != nil {
+			t.Fatal(fmt.Sprintf("failed to start child process. errMsg[%s]", startErr))
+		}
+
+		// 子进程写入期间反复读取，每次都只能读到完整的旧值或新值
+		deadline := time.Now().Add(time.Duration(100+round*50) * time.Millisecond)
+		for time.Now().Before(deadline) {
+			dataFiles, _ := filepath.Glob(filepath.Join(dir, "*", "*.zzkv"))
+			if len(dataFiles) == 0 {
+				continue
+			}
+			data, readErr := ioutil.ReadFile(dataFiles[0])
+			if readErr != nil {
+				_ = cmd.Process.Kill()
+				t.Fatal(fmt.Sprintf("failed to read while writing. errMsg[%s]", readErr))
+			}
+			if !isAtomicWriteValue(data) {
+				_ = cmd.Process.Kill()
+				t.Fatal(fmt.Sprintf("torn value read while writing. round:%d, size:%d", round, len(data)))
+			}
+		}
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		// 被杀之后重新打开，读到的仍是完整的值
+		s, openErr := zzkv.NewDefaultPstStorager(&zzkv.Options{DataDir: dir})
+		if openErr != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen storager. errMsg[%s]", openErr))
+		}
+		fetchVal, readErr := s.Read("nba")
+		_ = s.Close()
+		if readErr != nil || !isAtomicWriteValue(fetchVal) {
+			t.Fatal(fmt.Sprintf("torn value after crash. round:%d, size:%d, errMsg[%v]", round, len(fetchVal), readErr))
+		}
+	}
+
+	t.Log("---------------Test PstStoragerCrashDuringWrite PASS------------------")
+}
@@ -1,14 +1,44 @@
 package test_test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/zzkv"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// 每个测试使用独立的临时数据目录，用完由调用方删除
+func newTestOptions(tb testing.TB) *zzkv.Options {
+	dir, err := ioutil.TempDir("", "zzkv_test")
+	if err != nil {
+		tb.Fatal(fmt.Sprintf("failed to create temp dir. errMsg[%s]", err))
+	}
+	opts := zzkv.DefaultOptions()
+	opts.DataDir = dir
+	return opts
+}
+
+func newTestPstStorager(tb testing.TB) (*zzkv.DefaultPstStorager, func()) {
+	opts := newTestOptions(tb)
+	s, err := zzkv.NewDefaultPstStorager(opts)
+	if err != nil {
+		tb.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	return s, func() {
+		_ = s.Close()
+		_ = os.RemoveAll(opts.DataDir)
+	}
+}
+
 func TestPstStorager(t *testing.T) {
-	s1 := zzkv.NewDefaultPstStorager()
+	s1, cleanup := newTestPstStorager(t)
+	defer cleanup()
 	key := "fucker"
 	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
 
@@ -17,8 +47,8 @@ func TestPstStorager(t *testing.T) {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal := s1.Read(key)
-	if string(fetchVal) != values[0] {
+	fetchVal, readErr := s1.Read(key)
+	if readErr != nil || string(fetchVal) != values[0] {
 		t.Fatal("Inconsistent access data.")
 	}
 
@@ -27,8 +57,8 @@ func TestPstStorager(t *testing.T) {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal = s1.Read(key)
-	if string(fetchVal) != values[1] {
+	fetchVal, readErr = s1.Read(key)
+	if readErr != nil || string(fetchVal) != values[1] {
 		t.Fatal("Inconsistent access data.")
 	}
 
@@ -36,8 +66,104 @@ func TestPstStorager(t *testing.T) {
 
 }
 
+func TestPstStoragerKeyEncoding(t *testing.T) {
+	s1, cleanup := newTestPstStorager(t)
+	defer cleanup()
+	keys := []string{"../../fucker", "/etc/passwd", "a/b/c", "nul\x00key", "", "..", "FUCKER", "fucker", strings.Repeat("长key", 500)}
+
+	for i, key := range keys {
+		setErr := s1.Storage(key, []byte(fmt.Sprintf("value_%d", i)))
+		if setErr != nil {
+			t.Fatal(fmt.Sprintf("failed to set. key[%q] errMsg[%s]", key, setErr))
+		}
+	}
+	for i, key := range keys {
+		fetchVal, readErr := s1.Read(key)
+		if readErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. key[%q] fetch value:%s", key, string(fetchVal)))
+		}
+	}
+
+	t.Log("---------------Test PstStoragerKeyEncoding PASS------------------")
+}
+
+func TestPstStoragerMigrateLegacyKeys(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+
+	// 旧版布局: 数据目录下直接以key命名
+	values := map[string]string{"fucker": "12345879&……%%我要怎么说--+++!@#$%", "bitcher": "bitcher zzkv渣渣键值对"}
+	for key, val := range values {
+		_ = ioutil.WriteFile(filepath.Join(opts.DataDir, key+".zzkv"), []byte(val), zzkv.DefaultFileMode)
+	}
+	// 同一目录下bitcask的数据文件和hint文件保持不动
+	bitcaskFiles := []string{"bitcask_000000001.zzkv", "bitcask_000000001.hint", "bitcask_2021-01-01.zzkv"}
+	for _, fileName := range bitcaskFiles {
+		_ = ioutil.WriteFile(filepath.Join(opts.DataDir, fileName), []byte("bitcher"), zzkv.DefaultFileMode)
+	}
+
+	s1, err := zzkv.NewDefaultPstStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+
+	migrated, err := s1.MigrateLegacyKeys()
+	if err != nil || migrated != len(values) {
+		t.Fatal(fmt.Sprintf("failed to migrate. migrated[%d] errMsg[%v]", migrated, err))
+	}
+	for key, val := range values {
+		fetchVal, readErr := s1.Read(key)
+		if readErr != nil || string(fetchVal) != val {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after migration. key[%s]", key))
+		}
+	}
+	for key := range values {
+		if _, statErr := os.Stat(filepath.Join(opts.DataDir, key+".zzkv")); !os.IsNotExist(statErr) {
+			t.Fatal(fmt.Sprintf("legacy file left. key[%s]", key))
+		}
+	}
+	for _, fileName := range bitcaskFiles {
+		data, readErr := ioutil.ReadFile(filepath.Join(opts.DataDir, fileName))
+		if readErr != nil || string(data) != "bitcher" {
+			t.Fatal(fmt.Sprintf("bitcask file moved by migration. file[%s] errMsg[%v]", fileName, readErr))
+		}
+	}
+
+	t.Log("---------------Test PstStoragerMigrateLegacyKeys PASS------------------")
+}
+
+func TestPstStoragerDelete(t *testing.T) {
+	s1, cleanup := newTestPstStorager(t)
+	defer cleanup()
+	keys := []string{"fucker", strings.Repeat("长key", 500)}
+
+	for _, key := range keys {
+		setErr := s1.Storage(key, []byte("bitcher zzkv渣渣键值对"))
+		if setErr != nil {
+			t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+		}
+		deleteErr := s1.Delete(key)
+		if deleteErr != nil {
+			t.Fatal(fmt.Sprintf("failed to delete. errMsg[%s]", deleteErr))
+		}
+		_, readErr := s1.Read(key)
+		if !errors.Is(readErr, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("deleted key still readable. errMsg[%v]", readErr))
+		}
+		// 重复删除不报其他错误
+		deleteErr = s1.Delete(key)
+		if !errors.Is(deleteErr, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("missing key not reported. errMsg[%v]", deleteErr))
+		}
+	}
+
+	t.Log("---------------Test PstStoragerDelete PASS------------------")
+}
+
 func BenchmarkPstStorager(b *testing.B) {
-	s1 := zzkv.NewDefaultPstStorager()
+	s1, cleanup := newTestPstStorager(b)
+	defer cleanup()
 	key := "fucker"
 	values := []string{"通过开源协作创建了大量优质编程教程", "已帮助全球数百万人学习编程", "非营利组织 freeCodeCamp", "成为开发者。在这里分享你的文章吧"}
 	b.ResetTimer()
@@ -48,15 +174,16 @@ func BenchmarkPstStorager(b *testing.B) {
 			b.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 		}
 
-		fetchVal := s1.Read(key)
-		if string(fetchVal) != values[i%3] {
+		fetchVal, readErr := s1.Read(key)
+		if readErr != nil || string(fetchVal) != values[i%3] {
 			b.Fatal("Inconsistent access data.")
 		}
 	}
 }
 
 func BenchmarkPstStoragerThreadSafety(b *testing.B) {
-	s1 := zzkv.NewDefaultPstStorager()
+	s1, cleanup := newTestPstStorager(b)
+	defer cleanup()
 	key := "fucker"
 	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
 	valMap := make(map[string]bool)
@@ -72,7 +199,7 @@ func BenchmarkPstStoragerThreadSafety(b *testing.B) {
 				b.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 			}
 
-			fetchVal := s1.Read(key)
+			fetchVal, _ := s1.Read(key)
 			if _, ok := valMap[string(fetchVal)]; !ok && string(fetchVal) != "" {
 				b.Fatal(fmt.Sprintf("Inconsistent access data. fetchedData:[%s]", string(fetchVal)))
 			}
@@ -91,8 +218,8 @@ func TestCacheStrager(t *testing.T)  {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal := s1.Get(key)
-	if string(fetchVal) != values[0] {
+	fetchVal, getErr := s1.Get(key)
+	if getErr != nil || string(fetchVal) != values[0] {
 		t.Fatal("Inconsistent access data.")
 	}
 
@@ -101,8 +228,8 @@ func TestCacheStrager(t *testing.T)  {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal = s1.Get(key)
-	if string(fetchVal) != values[1] {
+	fetchVal, getErr = s1.Get(key)
+	if getErr != nil || string(fetchVal) != values[1] {
 		t.Fatal("Inconsistent access data.")
 	}
 
@@ -122,8 +249,8 @@ func BenchmarkCacheStorager (b *testing.B) {
 			b.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 		}
 
-		fetchVal := s1.Get(key)
-		if string(fetchVal) != values[i%4] {
+		fetchVal, getErr := s1.Get(key)
+		if getErr != nil || string(fetchVal) != values[i%4] {
 			b.Fatal("Inconsistent access data.")
 		}
 	}
@@ -146,7 +273,7 @@ func BenchmarkCacheStoragerThreadSafety(b *testing.B) {
 				b.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 			}
 
-			fetchVal := s1.Get(key)
+			fetchVal, _ := s1.Get(key)
 			if _, ok := valMap[string(fetchVal)]; !ok && string(fetchVal) != "" {
 				b.Fatal(fmt.Sprintf("Inconsistent access data. fetchedData:[%s]", string(fetchVal)))
 			}
@@ -156,7 +283,12 @@ func BenchmarkCacheStoragerThreadSafety(b *testing.B) {
 
 
 func TestStorager(t *testing.T) {
-	s1 := zzkv.NewDefaultStorager()
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
 	key := "fucker"
 	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
 
@@ -166,8 +298,8 @@ func TestStorager(t *testing.T) {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal := s1.Get(key)
-	if string(fetchVal) != values[0] {
+	fetchVal, getErr := s1.Get(key)
+	if getErr != nil || string(fetchVal) != values[0] {
 		t.Fatal(fmt.Sprintf("Inconsistent access data. index:0, fetch value:%s", string(fetchVal)))
 	}
 
@@ -176,16 +308,62 @@ func TestStorager(t *testing.T) {
 		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 	}
 
-	fetchVal = s1.Get(key)
-	if string(fetchVal) != values[1] {
+	fetchVal, getErr = s1.Get(key)
+	if getErr != nil || string(fetchVal) != values[1] {
 		t.Fatal(fmt.Sprintf("Inconsistent access data. index:1, fetch value:%s", string(fetchVal)))
 	}
 
 	t.Log("---------------Test Storager PASS------------------")
 }
 
+func TestStoragerErase(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	key := "fucker"
+
+	setErr := s1.Set(key, []byte("fucker说什么"), true)
+	if setErr != nil {
+		t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+	}
+	eraseErr := s1.Erase(key)
+	if eraseErr != nil {
+		t.Fatal(fmt.Sprintf("failed to erase. errMsg[%s]", eraseErr))
+	}
+
+	_, getErr := s1.Get(key)
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("erased key still readable. errMsg[%v]", getErr))
+	}
+	eraseErr = s1.Erase(key)
+	if !errors.Is(eraseErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("missing key not reported. errMsg[%v]", eraseErr))
+	}
+
+	// 只在缓存中的key同样算删除成功
+	_ = s1.Set(key, []byte("fucker说什么"), false)
+	eraseErr = s1.Erase(key)
+	if eraseErr != nil {
+		t.Fatal(fmt.Sprintf("failed to erase cached key. errMsg[%s]", eraseErr))
+	}
+	_, getErr = s1.Get(key)
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("erased cached key still readable. errMsg[%v]", getErr))
+	}
+
+	t.Log("---------------Test StoragerErase PASS------------------")
+}
+
 func BenchmarkStoragerThreadSafety(b *testing.B) {
-	s1 := zzkv.NewDefaultStorager()
+	opts := newTestOptions(b)
+	defer os.RemoveAll(opts.DataDir)
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		b.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
 	key := "bitcher"
 	values := []string{"12345879&……%%我要怎么说--+++!@#$%", "45879&……%%我要怎么说-", "fucker说什么", "bitcher zzkv渣渣键值对"}
 	valMap := make(map[string]bool)
@@ -201,7 +379,7 @@ func BenchmarkStoragerThreadSafety(b *testing.B) {
 			b.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
 		}
 
-		fetchVal := s1.Get(key)
+		fetchVal, _ := s1.Get(key)
 		if _, ok := valMap[string(fetchVal)]; !ok && string(fetchVal) != "" {
 			b.Fatal(fmt.Sprintf("Inconsistent access data. fetchedData:[%s]", string(fetchVal)))
 		}
@@ -210,3 +388,391 @@ func BenchmarkStoragerThreadSafety(b *testing.B) {
 
 
 
+
+func TestStoragerReopen(t *testing.T) {
+	keys := []string{"fucker", "", "a/b/c", strings.Repeat("长key", 500)}
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		opts := newTestOptions(t)
+		opts.Backend = backend
+
+		// 上一个进程写入的数据
+		pstStorager, err := zzkv.NewPstStorager(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+		}
+		for i, key := range keys {
+			setErr := pstStorager.Storage(key, []byte(fmt.Sprintf("value_%d", i)))
+			if setErr != nil {
+				t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+			}
+		}
+		storedKeys, keysErr := pstStorager.Keys()
+		if keysErr != nil || len(storedKeys) != len(keys) {
+			t.Fatal(fmt.Sprintf("unexpected keys. backend[%d] keys[%q] errMsg[%v]", backend, storedKeys, keysErr))
+		}
+		_ = pstStorager.Close()
+
+		s1, err := zzkv.NewDefaultStorager(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+		}
+		for i, key := range keys {
+			fetchVal, getErr := s1.Get(key)
+			if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i) {
+				t.Fatal(fmt.Sprintf("Inconsistent access data after reopen. backend[%d] key[%q] errMsg[%v]", backend, key, getErr))
+			}
+		}
+		_ = os.RemoveAll(opts.DataDir)
+	}
+
+	t.Log("---------------Test StoragerReopen PASS------------------")
+}
+
+func TestLRUCacheStorager(t *testing.T) {
+	s1 := zzkv.NewLRUCacheStorager(3, 10)
+	for _, key := range []string{"a", "b", "c"} {
+		_ = s1.Set(key, []byte(key+key))
+	}
+	// 访问a之后，最久未访问的是b
+	_, _ = s1.Get("a")
+	_ = s1.Set("d", []byte("dd"))
+	if s1.IsExist("b") || !s1.IsExist("a") || !s1.IsExist("d") {
+		t.Fatal("least recently used entry not evicted by entry limit.")
+	}
+
+	// 超出字节上限，依次淘汰c、a
+	_ = s1.Set("e", []byte("eeeeeee"))
+	if s1.IsExist("c") || s1.IsExist("a") || !s1.IsExist("e") {
+		t.Fatal("least recently used entry not evicted by byte limit.")
+	}
+	// 单个value超过字节上限不缓存
+	_ = s1.Set("f", []byte("fffffffffff"))
+	if s1.IsExist("f") {
+		t.Fatal("value larger than byte limit cached.")
+	}
+
+	_, getErr := s1.Get("b")
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("evicted key not reported. errMsg[%v]", getErr))
+	}
+	stats := s1.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 3 || stats.Entries != 2 || stats.Bytes != 9 {
+		t.Fatal(fmt.Sprintf("unexpected cache stats. stats[%+v]", stats))
+	}
+
+	t.Log("---------------Test LRUCacheStorager PASS------------------")
+}
+
+func TestStoragerLRUCache(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.Cache = zzkv.LRUCache
+	opts.CacheMaxEntries = 10
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+
+	for i := 0; i < 100; i++ {
+		setErr := s1.Set(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i)), true)
+		if setErr != nil {
+			t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+		}
+	}
+	// 最近写入的10个key命中缓存，其余从持久化存储读回
+	for i := 99; i >= 0; i-- {
+		fetchVal, getErr := s1.Get(fmt.Sprintf("key_%d", i))
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. index:%d, errMsg[%v]", i, getErr))
+		}
+	}
+
+	stats := s1.CacheStats()
+	if stats.Entries != 10 || stats.Misses != 90 || stats.Hits != 10 {
+		t.Fatal(fmt.Sprintf("unexpected cache stats. stats[%+v]", stats))
+	}
+
+	t.Log("---------------Test StoragerLRUCache PASS------------------")
+}
+
+// 未持久化的新值被淘汰后，不会读到持久化存储中的旧值
+func TestStoragerLRUCacheUnsyncedEviction(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.Cache = zzkv.LRUCache
+	opts.CacheMaxEntries = 1
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+
+	_ = s1.Set("nba", []byte("old"), true)
+	_ = s1.Set("nba", []byte("new"), false)
+	_ = s1.Set("cba", []byte("fucker说什么"), true)
+
+	fetchVal, getErr := s1.Get("nba")
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("stale persisted value returned. fetch value:%s, errMsg[%v]", string(fetchVal), getErr))
+	}
+
+	t.Log("---------------Test StoragerLRUCacheUnsyncedEviction PASS------------------")
+}
+
+func BenchmarkLRUCacheStorager(b *testing.B) {
+	s1 := zzkv.NewLRUCacheStorager(1024, 0)
+	value := []byte("bitcher zzkv渣渣键值对")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		key := fmt.Sprintf("key_%d", i%2048)
+		if _, getErr := s1.Get(key); getErr != nil {
+			_ = s1.Set(key, value)
+		}
+	}
+}
+
+func TestStoragerWriteBack(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.WriteBack = true
+	opts.FlushInterval = time.Hour
+	opts.Cache = zzkv.LRUCache
+	opts.CacheMaxEntries = 10
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+
+	for i := 0; i < 100; i++ {
+		setErr := s1.Set(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i)), false)
+		if setErr != nil {
+			t.Fatal(fmt.Sprintf("failed to set. errMsg[%s]", setErr))
+		}
+	}
+	// 已被缓存淘汰但尚未回写的value仍然可读
+	fetchVal, getErr := s1.Get("key_0")
+	if getErr != nil || string(fetchVal) != "value_0" {
+		t.Fatal(fmt.Sprintf("dirty value lost after eviction. errMsg[%v]", getErr))
+	}
+	if stats := s1.WriteBackStats(); stats.Dirty != 100 {
+		t.Fatal(fmt.Sprintf("unexpected write back stats. stats[%+v]", stats))
+	}
+
+	// 只存在于回写队列中的key也能删除
+	eraseErr := s1.Erase("key_1")
+	if eraseErr != nil {
+		t.Fatal(fmt.Sprintf("failed to erase dirty key. errMsg[%s]", eraseErr))
+	}
+	_, getErr = s1.Get("key_1")
+	if !errors.Is(getErr, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("erased dirty key still readable. errMsg[%v]", getErr))
+	}
+
+	flushErr := s1.Flush()
+	if stats := s1.WriteBackStats(); flushErr != nil || stats.Dirty != 0 || stats.Flushed != 99 {
+		t.Fatal(fmt.Sprintf("failed to flush. stats[%+v] errMsg[%v]", stats, flushErr))
+	}
+	_ = s1.Set("key_100", []byte("value_100"), false)
+	closeErr := s1.Close()
+	if closeErr != nil {
+		t.Fatal(fmt.Sprintf("failed to close. errMsg[%s]", closeErr))
+	}
+
+	// 重启后所有回写的数据都在
+	s2, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen storager. errMsg[%s]", err))
+	}
+	defer s2.Close()
+	for i := 0; i <= 100; i++ {
+		fetchVal, getErr := s2.Get(fmt.Sprintf("key_%d", i))
+		if i == 1 {
+			if !errors.Is(getErr, zzkv.ErrNotFound) {
+				t.Fatal(fmt.Sprintf("erased key came back. errMsg[%v]", getErr))
+			}
+			continue
+		}
+		if getErr != nil || string(fetchVal) != fmt.Sprintf("value_%d", i) {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after reopen. index:%d, errMsg[%v]", i, getErr))
+		}
+	}
+
+	t.Log("---------------Test StoragerWriteBack PASS------------------")
+}
+
+func TestStoragerWriteBackFlusher(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.WriteBack = true
+	opts.FlushInterval = time.Hour
+	opts.FlushBytes = 64
+	flushErrs := make(chan string, 100)
+	opts.OnFlushError = func(key string, err error) {
+		flushErrs <- key
+	}
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+
+	// 脏数据达到FlushBytes时后台协程立即回写
+	_ = s1.Set("nba", []byte(strings.Repeat("bitcher", 10)), false)
+	deadline := time.Now().Add(5 * time.Second)
+	for s1.WriteBackStats().Dirty != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal(fmt.Sprintf("dirty bytes threshold not honoured. stats[%+v]", s1.WriteBackStats()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 数据目录不可写，回写失败时回调，key保留到下次重试
+	_ = os.RemoveAll(opts.DataDir)
+	_ = ioutil.WriteFile(opts.DataDir, nil, zzkv.DefaultFileMode)
+	_ = s1.Set("cba", []byte("fucker说什么"), false)
+	flushErr := s1.Flush()
+	if flushErr == nil || <-flushErrs != "cba" || s1.WriteBackStats().Dirty != 1 {
+		t.Fatal(fmt.Sprintf("flush error not reported. stats[%+v] errMsg[%v]", s1.WriteBackStats(), flushErr))
+	}
+	_ = os.Remove(opts.DataDir)
+	_ = os.Mkdir(opts.DataDir, zzkv.DefaultDirMode)
+	flushErr = s1.Flush()
+	if stats := s1.WriteBackStats(); flushErr != nil || stats.Dirty != 0 || stats.FlushErrors == 0 {
+		t.Fatal(fmt.Sprintf("failed to retry flush. stats[%+v] errMsg[%v]", stats, flushErr))
+	}
+
+	t.Log("---------------Test StoragerWriteBackFlusher PASS------------------")
+}
+
+func TestStoragerWriteBackConcurrentFlush(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.WriteBack = true
+	opts.FlushInterval = time.Hour
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+
+	for i := 0; i < 2000; i++ {
+		_ = s1.Set(fmt.Sprintf("key_%d", i), []byte(fmt.Sprintf("value_%d", i)), false)
+	}
+	// 回写不持有锁，期间的删除、同步写入和再次写入脏数据都不会被回写的旧值覆盖
+	flushDone := make(chan error)
+	go func() {
+		flushDone <- s1.Flush()
+	}()
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		switch i % 3 {
+		case 0:
+			_ = s1.Erase(key)
+		case 1:
+			_ = s1.Set(key, []byte("synced"), true)
+		default:
+			_ = s1.Set(key, []byte("dirty"), false)
+		}
+		if _, getErr := s1.Get(fmt.Sprintf("key_%d", 1000+i)); getErr != nil {
+			t.Fatal(fmt.Sprintf("failed to get during flush. errMsg[%s]", getErr))
+		}
+	}
+	if flushErr := <-flushDone; flushErr != nil {
+		t.Fatal(fmt.Sprintf("failed to flush. errMsg[%s]", flushErr))
+	}
+	closeErr := s1.Close()
+	if closeErr != nil {
+		t.Fatal(fmt.Sprintf("failed to close. errMsg[%s]", closeErr))
+	}
+
+	s2, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to reopen storager. errMsg[%s]", err))
+	}
+	defer s2.Close()
+	for i := 0; i < 2000; i++ {
+		fetchVal, getErr := s2.Get(fmt.Sprintf("key_%d", i))
+		expected := fmt.Sprintf("value_%d", i)
+		if i < 300 {
+			switch i % 3 {
+			case 0:
+				if !errors.Is(getErr, zzkv.ErrNotFound) {
+					t.Fatal(fmt.Sprintf("erased key came back. index:%d, errMsg[%v]", i, getErr))
+				}
+				continue
+			case 1:
+				expected = "synced"
+			default:
+				expected = "dirty"
+			}
+		}
+		if getErr != nil || string(fetchVal) != expected {
+			t.Fatal(fmt.Sprintf("Inconsistent access data after reopen. index:%d, errMsg[%v]", i, getErr))
+		}
+	}
+
+	t.Log("---------------Test StoragerWriteBackConcurrentFlush PASS------------------")
+}
+
+func TestStoragerNegativeCache(t *testing.T) {
+	opts := newTestOptions(t)
+	defer os.RemoveAll(opts.DataDir)
+	opts.NegativeCacheEntries = 2
+	opts.NegativeCacheTTL = 50 * time.Millisecond
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+
+	for i := 0; i < 10; i++ {
+		_, getErr := s1.Get("nba")
+		if !errors.Is(getErr, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("missing key not reported. errMsg[%v]", getErr))
+		}
+	}
+	if stats := s1.NegativeCacheStats(); stats.Hits != 9 || stats.Entries != 1 {
+		t.Fatal(fmt.Sprintf("unexpected negative cache stats. stats[%+v]", stats))
+	}
+
+	// 写入后负缓存失效
+	_ = s1.Set("nba", []byte("bitcher zzkv渣渣键值对"), true)
+	fetchVal, getErr := s1.Get("nba")
+	if getErr != nil || string(fetchVal) != "bitcher zzkv渣渣键值对" {
+		t.Fatal(fmt.Sprintf("negative cache not invalidated by set. errMsg[%v]", getErr))
+	}
+
+	// 容量有上限
+	for _, key := range []string{"a", "b", "c"} {
+		_, _ = s1.Get(key)
+	}
+	if stats := s1.NegativeCacheStats(); stats.Entries != 2 {
+		t.Fatal(fmt.Sprintf("negative cache not bounded. stats[%+v]", stats))
+	}
+
+	// 过期后重新确认
+	hits := s1.NegativeCacheStats().Hits
+	time.Sleep(2 * opts.NegativeCacheTTL)
+	_, _ = s1.Get("c")
+	if stats := s1.NegativeCacheStats(); stats.Hits != hits {
+		t.Fatal(fmt.Sprintf("expired negative entry used. stats[%+v]", stats))
+	}
+
+	t.Log("---------------Test StoragerNegativeCache PASS------------------")
+}
+
+func BenchmarkStoragerNegativeCache(b *testing.B) {
+	opts := newTestOptions(b)
+	defer os.RemoveAll(opts.DataDir)
+	opts.NegativeCacheEntries = 1024
+	s1, err := zzkv.NewDefaultStorager(opts)
+	if err != nil {
+		b.Fatal(fmt.Sprintf("failed to create storager. errMsg[%s]", err))
+	}
+	defer s1.Close()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = s1.Get(fmt.Sprintf("missing_%d", i%1000))
+	}
+}
@@ -0,0 +1,77 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zzkv"
+)
+
+func TestTypedStore(t *testing.T) {
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		z1, cleanup := newTestZzkv(t, backend)
+
+		// 默认JSON编码，与Zzkv.Get互通
+		structs := zzkv.NewTypedStore[TestStt](z1, nil)
+		t1 := TestStt{X:"fucker", Y:"shiter"}
+		err := structs.Set("nba", t1)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to set kv. backend:%d, errMsg[%s]", backend, err))
+		}
+		fetchVal, err := structs.Get("nba")
+		if err != nil || fetchVal != t1 {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. backend:%d, fetch value:%+v, errMsg[%v]", backend, fetchVal, err))
+		}
+		t2 := &TestStt{}
+		if err = z1.Get("nba", t2); err != nil || *t2 != t1 {
+			t.Fatal(fmt.Sprintf("typed value not readable by Zzkv. backend:%d, errMsg[%v]", backend, err))
+		}
+
+		// 按类型选择编解码器
+		gobs := zzkv.NewTypedStore[map[string]int](z1, zzkv.GobCodec[map[string]int]{})
+		_ = gobs.Set("cba", map[string]int{"fucker": 1, "shiter": 2})
+		if counts := gobs.MustGet("cba"); len(counts) != 2 || counts["shiter"] != 2 {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. backend:%d, fetch value:%v", backend, counts))
+		}
+		strs := zzkv.NewTypedStore[string](z1, zzkv.StringCodec{})
+		_ = strs.Set("str", "bitcher zzkv渣渣键值对")
+		if str := strs.MustGet("str"); str != "bitcher zzkv渣渣键值对" {
+			t.Fatal(fmt.Sprintf("Inconsistent access data. backend:%d, fetch value:%s", backend, str))
+		}
+
+		// 编码不匹配的数据
+		if _, err = gobs.Get("nba"); !errors.Is(err, zzkv.ErrCorrupt) {
+			t.Fatal(fmt.Sprintf("mismatched codec not reported as corrupt. backend:%d, errMsg[%v]", backend, err))
+		}
+
+		// 不存在的key
+		fetchVal, err = structs.Get("missing")
+		if !errors.Is(err, zzkv.ErrNotFound) || fetchVal != (TestStt{}) {
+			t.Fatal(fmt.Sprintf("missing kv returned. backend:%d, errMsg[%v]", backend, err))
+		}
+		func() {
+			defer func() {
+				panicErr, ok := recover().(error)
+				if !ok || !errors.Is(panicErr, zzkv.ErrNotFound) {
+					t.Fatal(fmt.Sprintf("MustGet did not panic with ErrNotFound. backend:%d, panic[%v]", backend, panicErr))
+				}
+			}()
+			structs.MustGet("missing")
+		}()
+
+		// 过期时间
+		_ = structs.SetWithTTL("ttl", t1, 60)
+		if ttl, _ := z1.TTL("ttl"); ttl <= 0 {
+			t.Fatal(fmt.Sprintf("ttl not set. backend:%d, ttl:%v", backend, ttl))
+		}
+		_ = structs.Set("ttl", t1)
+		if ttl, _ := z1.TTL("ttl"); ttl != zzkv.NoTTL {
+			t.Fatal(fmt.Sprintf("ttl left over after Set. backend:%d, ttl:%v", backend, ttl))
+		}
+
+		cleanup()
+	}
+
+	t.Log("----------------Test TypedStore PASS--------------------")
+}
@@ -1,19 +1,58 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"github.com/zzkv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 )
 
+// 在临时数据目录下创建，返回的函数负责关闭并删除目录
+func newTestZzkv(t *testing.T, backend zzkv.Backend) (*zzkv.Zzkv, func()) {
+	return newTestZzkvWithOptions(t, &zzkv.Options{Backend:backend})
+}
+
+// 同newTestZzkv，opts.DataDir被改为临时目录，可用opts重新打开，未设置的配置项使用默认值
+func newTestZzkvWithOptions(t testing.TB, opts *zzkv.Options) (*zzkv.Zzkv, func()) {
+	dir, err := ioutil.TempDir("", "zzkv_test")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create temp dir. errMsg[%s]", err))
+	}
+	opts.DataDir = dir
+	if opts.ClearInterval == 0 {
+		// 过期数据只由测试主动清理
+		opts.ClearInterval = time.Hour
+	}
+
+	z, err := zzkv.NewDefault(opts)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create zzkv. errMsg[%s]", err))
+	}
+	return z, func() {
+		_ = z.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
 type TestStt struct {
 	X string	`json:"x"`
 	Y string	`json:"y"`
 }
 
 func TestZzkv(t *testing.T)  {
-	z1 := zzkv.NewDefault()
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		testZzkv(t, backend)
+	}
+}
+
+func testZzkv(t *testing.T, backend zzkv.Backend)  {
+	z1, cleanup := newTestZzkv(t, backend)
+	defer cleanup()
 	t1 := TestStt{X:"fucker", Y:"shiter"}
 	t2 := &TestStt{}
 	key := "nba"
@@ -35,21 +74,431 @@ func TestZzkv(t *testing.T)  {
 	t.Log("----------------Test Zzkv PASS--------------------")
 }
 
+func TestZzkvDataDir(t *testing.T) {
+	patterns := map[zzkv.Backend]string{zzkv.FileBackend: "*/*.zzkv", zzkv.BitcaskBackend: "bitcask_*.zzkv"}
+	for backend, pattern := range patterns {
+		dir, err := ioutil.TempDir("", "zzkv_test")
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to create temp dir. errMsg[%s]", err))
+		}
+		dataDir := filepath.Join(dir, "data")
+
+		z1, err := zzkv.NewDefault(&zzkv.Options{DataDir:dataDir, FileMode:0600, Backend:backend})
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to create zzkv. errMsg[%s]", err))
+		}
+		err = z1.Set("nba", TestStt{X:"fucker", Y:"shiter"}, true)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to set kv. errMsg[%s]", err))
+		}
+
+		dataFiles, _ := filepath.Glob(filepath.Join(dataDir, pattern))
+		if len(dataFiles) != 1 {
+			t.Fatal(fmt.Sprintf("data file not written into data dir. backend[%d] files[%v]", backend, dataFiles))
+		}
+		info, _ := os.Stat(dataFiles[0])
+		if info.Mode().Perm() != 0600 {
+			t.Fatal(fmt.Sprintf("file mode not honoured. backend[%d] mode[%s]", backend, info.Mode()))
+		}
+		_ = z1.Close()
+		_ = os.RemoveAll(dir)
+	}
+
+	t.Log("----------------Test ZzkvDataDir PASS--------------------")
+}
+
 func TestZzkvClear(t *testing.T) {
-	z1 := zzkv.NewDefault()
+	z1, cleanup := newTestZzkv(t, zzkv.FileBackend)
+	defer cleanup()
 	t1 := TestStt{X:"fucker", Y:"shiter"}
 	t2 := &TestStt{}
 	key := "nba"
 
-	err := z1.SetWithTTL(key, t1, true, 5)
+	err := z1.SetWithTTL(key, t1, true, 1)
 	if err != nil {
 		t.Fatal(fmt.Sprintf("Failed to set kv. errMsg[%s]", err))
 	}
-	time.Sleep(time.Second*10)
 	err = z1.Get(key, t2)
 	if err != nil {
 		t.Fatal(fmt.Sprintf("Failed to get kv. errMsg[%s]", err))
 	}
 
+	// 过期之后立即读不到，不必等后台清理
+	time.Sleep(time.Millisecond*1100)
+	err = z1.Get(key, t2)
+	if !errors.Is(err, zzkv.ErrNotFound) {
+		t.Fatal(fmt.Sprintf("expired kv returned. errMsg[%v]", err))
+	}
+	exists, err := z1.Exists(key)
+	if err != nil || exists {
+		t.Fatal(fmt.Sprintf("expired kv reported as existing. errMsg[%v]", err))
+	}
+	exists, err = z1.Exists("cba")
+	if err != nil || exists {
+		t.Fatal(fmt.Sprintf("missing kv reported as existing. errMsg[%v]", err))
+	}
+
+	// 后台清理删除过期数据
+	exists, _ = z1.Storager.Exists(key)
+	if !exists {
+		t.Fatal("expired kv reclaimed before sweep.")
+	}
+	z1.Clear.TimingErase(z1.Storager)
+	exists, _ = z1.Storager.Exists(key)
+	if exists {
+		t.Fatal("expired kv not reclaimed by sweep.")
+	}
+
 	t.Log("----------------Test ZzkvClear PASS--------------------")
 }
+
+func TestZzkvClose(t *testing.T) {
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		opts := &zzkv.Options{Backend:backend, WriteBack:true, FlushInterval:time.Hour}
+		goroutines := runtime.NumGoroutine()
+		z1, cleanup := newTestZzkvWithOptions(t, opts)
+		err := z1.Set("nba", TestStt{X:"fucker", Y:"shiter"}, false)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to set kv. errMsg[%s]", err))
+		}
+		err = z1.Close()
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to close zzkv. errMsg[%s]", err))
+		}
+
+		// 后台协程全部退出
+		if runtime.NumGoroutine() > goroutines {
+			t.Fatal(fmt.Sprintf("goroutines leaked after close. backend[%d] before[%d] after[%d]", backend, goroutines, runtime.NumGoroutine()))
+		}
+		// 关闭后的调用都返回ErrClosed
+		if err = z1.Set("nba", TestStt{}, true); !errors.Is(err, zzkv.ErrClosed) {
+			t.Fatal(fmt.Sprintf("set after close not rejected. backend[%d] errMsg[%v]", backend, err))
+		}
+		if err = z1.Get("nba", &TestStt{}); !errors.Is(err, zzkv.ErrClosed) {
+			t.Fatal(fmt.Sprintf("get after close not rejected. backend[%d] errMsg[%v]", backend, err))
+		}
+		if err = z1.Close(); !errors.Is(err, zzkv.ErrClosed) {
+			t.Fatal(fmt.Sprintf("second close not rejected. backend[%d] errMsg[%v]", backend, err))
+		}
+
+		// 目录锁已释放，回写的数据已持久化
+		z2, err := zzkv.NewDefault(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen zzkv. backend[%d] errMsg[%s]", backend, err))
+		}
+		t2 := &TestStt{}
+		err = z2.Get("nba", t2)
+		if err != nil || t2.X != "fucker" {
+			t.Fatal(fmt.Sprintf("pending write lost on close. backend[%d] errMsg[%v]", backend, err))
+		}
+		_ = z2.Close()
+		cleanup()
+	}
+
+	t.Log("----------------Test ZzkvClose PASS--------------------")
+}
+
+func TestZzkvClearSweep(t *testing.T) {
+	z1, cleanup := newTestZzkvWithOptions(t, &zzkv.Options{ClearInterval:10 * time.Millisecond, ClearBatchSize:7})
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		// 偶数key立即过期，奇数key一小时后过期
+		err := z1.SetWithTTL(fmt.Sprintf("key_%d", i), TestStt{X:"fucker", Y:"shiter"}, true, int64(i%2)*3600)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to set kv. errMsg[%s]", err))
+		}
+	}
+	// 重新设置的过期时间生效
+	z1.Clear.Mark("key_1", 0)
+
+	// 分批删除，直到所有过期key都被清理
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; i < 100; i++ {
+		expired := i%2 == 0 || i == 1
+		for {
+			exists, _ := z1.Storager.Exists(fmt.Sprintf("key_%d", i))
+			if exists != expired {
+				break
+			}
+			if !expired || time.Now().After(deadline) {
+				t.Fatal(fmt.Sprintf("unexpected sweep result. index:%d, exists:%v", i, exists))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Log("----------------Test ZzkvClearSweep PASS--------------------")
+}
+
+// 大量未到期的key不影响清理的开销
+func BenchmarkClearTimingErase(b *testing.B) {
+	z1, cleanup := newTestZzkvWithOptions(b, &zzkv.Options{})
+	defer cleanup()
+	clear := zzkv.NewDefaultClear()
+	for i := 0; i < 100000; i++ {
+		clear.Mark(fmt.Sprintf("key_%d", i), 3600)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		clear.TimingErase(z1.Storager)
+	}
+}
+
+// 大批key同时过期时，清理逐个加锁删除，写入的最大延迟只有一次删除的开销
+func BenchmarkZzkvSetDuringSweep(b *testing.B) {
+	z1, cleanup := newTestZzkvWithOptions(b, &zzkv.Options{})
+	defer cleanup()
+	t1 := TestStt{X:"fucker", Y:"shiter"}
+	for i := 0; i < 512; i++ {
+		key := fmt.Sprintf("key_%d", i)
+		_ = z1.SetWithTTL(key, t1, true, 3600)
+		z1.Clear.MarkAt(key, time.Now().UnixNano())
+	}
+	sweepDone := make(chan struct{})
+	go func() {
+		z1.Clear.TimingErase(z1.Storager)
+		close(sweepDone)
+	}()
+	b.ResetTimer()
+
+	var maxLatency time.Duration
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		_ = z1.Set(fmt.Sprintf("live_%d", i%1024), t1, false)
+		if latency := time.Since(start); latency > maxLatency {
+			maxLatency = latency
+		}
+	}
+	b.StopTimer()
+	<-sweepDone
+	b.ReportMetric(float64(maxLatency.Nanoseconds()), "max-ns/op")
+}
+
+// 过期时间随数据持久化，重启后依然生效，停机期间过期的key重启后不存在
+func TestZzkvTTLRestart(t *testing.T) {
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		opts := &zzkv.Options{Backend:backend, WriteBack:true, FlushInterval:time.Hour}
+		z1, cleanup := newTestZzkvWithOptions(t, opts)
+		t1 := TestStt{X:"fucker", Y:"shiter"}
+		_ = z1.SetWithTTL("short", t1, true, 1)
+		_ = z1.SetWithTTL("long", t1, true, 2)
+		// 回写的数据同样带上过期时间
+		_ = z1.SetWithTTL("dirty", t1, false, 2)
+		_ = z1.Set("plain", t1, true)
+		_ = z1.Close()
+		time.Sleep(time.Millisecond*1100)
+
+		z2, err := zzkv.NewDefault(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen zzkv. backend:%d, errMsg[%s]", backend, err))
+		}
+		t2 := &TestStt{}
+		if err = z2.Get("short", t2); !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("key expired during restart came back. backend:%d, errMsg[%v]", backend, err))
+		}
+		if exists, _ := z2.Storager.Exists("short"); exists {
+			t.Fatal(fmt.Sprintf("key expired during restart still stored. backend:%d", backend))
+		}
+		for _, key := range []string{"long", "dirty", "plain"} {
+			if err = z2.Get(key, t2); err != nil || *t2 != t1 {
+				t.Fatal(fmt.Sprintf("Inconsistent access data after restart. backend:%d, key:%s, errMsg[%v]", backend, key, err))
+			}
+		}
+
+		// 重启前设置的过期时间依然生效
+		time.Sleep(time.Second)
+		for _, key := range []string{"long", "dirty"} {
+			if err = z2.Get(key, t2); !errors.Is(err, zzkv.ErrNotFound) {
+				t.Fatal(fmt.Sprintf("ttl lost after restart. backend:%d, key:%s, errMsg[%v]", backend, key, err))
+			}
+		}
+		if err = z2.Get("plain", t2); err != nil {
+			t.Fatal(fmt.Sprintf("Failed to get kv. backend:%d, errMsg[%s]", backend, err))
+		}
+		_ = z2.Close()
+		cleanup()
+	}
+
+	t.Log("----------------Test ZzkvTTLRestart PASS--------------------")
+}
+
+// 查询、延长、去掉过期时间，修改后的过期时间重启后依然有效
+func TestZzkvTTLManagement(t *testing.T) {
+	for _, backend := range []zzkv.Backend{zzkv.FileBackend, zzkv.BitcaskBackend} {
+		opts := &zzkv.Options{Backend:backend}
+		z1, cleanup := newTestZzkvWithOptions(t, opts)
+		t1 := TestStt{X:"fucker", Y:"shiter"}
+		_ = z1.SetWithTTL("session", t1, true, 60)
+		ttl, err := z1.TTL("session")
+		if err != nil || ttl <= 59*time.Second || ttl > 60*time.Second {
+			t.Fatal(fmt.Sprintf("unexpected ttl. backend:%d, ttl:%v, errMsg[%v]", backend, ttl, err))
+		}
+
+		// 延长
+		err = z1.Expire("session", 120)
+		ttl, _ = z1.TTL("session")
+		if err != nil || ttl <= 119*time.Second {
+			t.Fatal(fmt.Sprintf("ttl not extended. backend:%d, ttl:%v, errMsg[%v]", backend, ttl, err))
+		}
+
+		// 去掉过期时间
+		err = z1.Persist("session")
+		ttl, _ = z1.TTL("session")
+		if err != nil || ttl != zzkv.NoTTL {
+			t.Fatal(fmt.Sprintf("ttl not removed. backend:%d, ttl:%v, errMsg[%v]", backend, ttl, err))
+		}
+
+		// 绝对过期时间
+		expireAt := time.Now().Add(time.Hour)
+		err = z1.ExpireAt("session", expireAt)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Failed to set expiry. backend:%d, errMsg[%s]", backend, err))
+		}
+
+		// Set清除之前的过期时间
+		_ = z1.SetWithTTL("token", t1, true, 60)
+		_ = z1.Set("token", t1, true)
+		ttl, _ = z1.TTL("token")
+		if ttl != zzkv.NoTTL {
+			t.Fatal(fmt.Sprintf("ttl left over after Set. backend:%d, ttl:%v", backend, ttl))
+		}
+
+		// 过去的时间立即过期
+		_ = z1.Set("gone", t1, true)
+		_ = z1.ExpireAt("gone", time.Now().Add(-time.Second))
+		if err = z1.Get("gone", &TestStt{}); !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("kv not expired. backend:%d, errMsg[%v]", backend, err))
+		}
+
+		// 不存在的key
+		if _, err = z1.TTL("cba"); !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("ttl of missing kv. backend:%d, errMsg[%v]", backend, err))
+		}
+		if err = z1.Expire("cba", 60); !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("expire missing kv. backend:%d, errMsg[%v]", backend, err))
+		}
+		if err = z1.Persist("gone"); !errors.Is(err, zzkv.ErrNotFound) {
+			t.Fatal(fmt.Sprintf("persist expired kv. backend:%d, errMsg[%v]", backend, err))
+		}
+		_ = z1.Close()
+
+		z2, err := zzkv.NewDefault(opts)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("failed to reopen zzkv. backend:%d, errMsg[%s]", backend, err))
+		}
+		ttl, err = z2.TTL("session")
+		if err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+			t.Fatal(fmt.Sprintf("ttl lost after restart. backend:%d, ttl:%v, errMsg[%v]", backend, ttl, err))
+		}
+		ttl, err = z2.TTL("token")
+		if err != nil || ttl != zzkv.NoTTL {
+			t.Fatal(fmt.Sprintf("ttl came back after restart. backend:%d, ttl:%v, errMsg[%v]", backend, ttl, err))
+		}
+		_ = z2.Close()
+		cleanup()
+	}
+
+	t.Log("----------------Test ZzkvTTLManagement PASS--------------------")
+}
+
+// 后台清理删除过期key、缓存淘汰key时异步通知回调
+func TestZzkvEvents(t *testing.T) {
+	z1, cleanup := newTestZzkvWithOptions(t, &zzkv.Options{Cache:zzkv.LRUCache, CacheMaxEntries:2, ClearInterval:10 * time.Millisecond})
+	defer cleanup()
+
+	expiredChan := make(chan string, 10)
+	evictedChan := make(chan string, 10)
+	z1.OnExpire(func(key string) {
+		expiredChan <- key
+	})
+	z1.OnEvict(func(key string) {
+		evictedChan <- key
+	})
+
+	t1 := TestStt{X:"fucker", Y:"shiter"}
+	for i := 0; i < 3; i++ {
+		_ = z1.Set(fmt.Sprintf("key_%d", i), t1, true)
+	}
+	select {
+	case key := <-evictedChan:
+		if key != "key_0" {
+			t.Fatal(fmt.Sprintf("unexpected evicted key. key:%s", key))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("evict event not delivered.")
+	}
+
+	_ = z1.ExpireAt("key_1", time.Now())
+	select {
+	case key := <-expiredChan:
+		if key != "key_1" {
+			t.Fatal(fmt.Sprintf("unexpected expired key. key:%s", key))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expire event not delivered.")
+	}
+
+	// 读取时发现过期、主动删除都不触发
+	_ = z1.Storager.Erase("key_2")
+	select {
+	case key := <-expiredChan:
+		t.Fatal(fmt.Sprintf("unexpected expire event. key:%s", key))
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	t.Log("----------------Test ZzkvEvents PASS--------------------")
+}
+
+// 只写入缓存、未开启回写的key过期删除时同样通知
+func TestZzkvEventsUnsynced(t *testing.T) {
+	z1, cleanup := newTestZzkvWithOptions(t, &zzkv.Options{ClearInterval:10 * time.Millisecond})
+	defer cleanup()
+
+	expiredChan := make(chan string, 10)
+	z1.OnExpire(func(key string) {
+		expiredChan <- key
+	})
+	_ = z1.SetWithTTL("nba", TestStt{X:"fucker", Y:"shiter"}, false, 0)
+	select {
+	case key := <-expiredChan:
+		if key != "nba" {
+			t.Fatal(fmt.Sprintf("unexpected expired key. key:%s", key))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expire event of unsynced kv not delivered.")
+	}
+	if exists, _ := z1.Storager.Exists("nba"); exists {
+		t.Fatal("expired unsynced kv still cached.")
+	}
+
+	t.Log("----------------Test ZzkvEventsUnsynced PASS--------------------")
+}
+
+// 回调阻塞时事件队列不会无限增长，写入也不会被阻塞
+func TestZzkvEventQueueBounded(t *testing.T) {
+	z1, cleanup := newTestZzkvWithOptions(t, &zzkv.Options{Cache:zzkv.LRUCache, CacheMaxEntries:1, EventQueueSize:4})
+	defer cleanup()
+
+	blockChan := make(chan struct{})
+	z1.OnEvict(func(key string) {
+		<-blockChan
+	})
+	for i := 0; i < 101; i++ {
+		_ = z1.Set(fmt.Sprintf("key_%d", i), TestStt{X:"fucker", Y:"shiter"}, false)
+	}
+	if stats := z1.EventStats(); stats.Dropped == 0 {
+		t.Fatal(fmt.Sprintf("events not dropped with a full queue. stats[%+v]", stats))
+	}
+
+	// Close交付完已入队的事件
+	close(blockChan)
+	_ = z1.Close()
+	stats := z1.EventStats()
+	if stats.Delivered+stats.Dropped != 100 || stats.Delivered > 5 {
+		t.Fatal(fmt.Sprintf("unexpected event stats. stats[%+v]", stats))
+	}
+
+	t.Log("----------------Test ZzkvEventQueueBounded PASS--------------------")
+}
@@ -1,64 +1,225 @@
 package zzkv
 
 import (
+	"container/heap"
 	"sync"
 	"time"
 )
 
+// Deprecated: 后台清理间隔改由Options.ClearInterval配置
 const IntervalDuration = 60		//60s
 
+const (
+	DefaultClearInterval  = time.Second	// 后台清理过期key的间隔
+	DefaultClearBatchSize = 128			// 每批删除的过期key数
+)
+
+// 没有设置过期时间的key，Zzkv.TTL返回该值
+const NoTTL time.Duration = -1
+
+
+type ttlItem struct {
+	key 		string
+	deadline 	int64	// 过期时间的UnixNano
+	index 		int		// 在堆中的下标
+}
+
+// 按过期时间排列的小顶堆，堆顶最先过期
+type ttlHeap []*ttlItem
+
+func (h ttlHeap) Len() int {
+	return len(h)
+}
+
+func (h ttlHeap) Less(i, j int) bool {
+	return h[i].deadline < h[j].deadline
+}
+
+func (h ttlHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ttlHeap) Push(x interface{}) {
+	item := x.(*ttlItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *ttlHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
 
+
+// 记录每个key的过期时间，读取时判断是否过期，后台定时删除过期key回收空间
+// 过期时间按小顶堆组织，清理时只访问已到期的key
 type Clear struct {
-	ttlMap 		map[string]int64
+	ttlMap 		map[string]*ttlItem
+	ttlHeap 	ttlHeap
+	sweeping 	map[string]bool		// 已从堆中取出、等待删除的key，期间重新标记或去掉过期时间时移除
+	interval 	time.Duration
+	batchSize 	int
+	onExpire 	func(key string)	// 过期key删除后调用，需在Run之前设置
+	stopChan 	chan struct{}
+	stopOnce 	sync.Once
+	wg 			sync.WaitGroup
 	sync.Mutex
 }
 
 func NewDefaultClear() *Clear {
+	return NewClear(DefaultClearInterval, DefaultClearBatchSize)
+}
+
+// interval为后台清理间隔，batchSize为每批删除的过期key数，不大于0时使用默认值
+func NewClear(interval time.Duration, batchSize int) *Clear {
+	if interval <= 0 {
+		interval = DefaultClearInterval
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultClearBatchSize
+	}
 	return &Clear{
-		make(map[string]int64),
-		sync.Mutex{},
+		ttlMap:make(map[string]*ttlItem),
+		sweeping:make(map[string]bool),
+		interval:interval,
+		batchSize:batchSize,
+		stopChan:make(chan struct{}),
 	}
 }
 
-// 标记过期时间
+// 标记过期时间，expireSeconds秒之后过期
 func (clear *Clear) Mark(key string, expireSeconds int64) {
+	clear.MarkAt(key, time.Now().Add(time.Duration(expireSeconds) * time.Second).UnixNano())
+}
+
+// 标记过期时间，deadline为过期时间的UnixNano
+func (clear *Clear) MarkAt(key string, deadline int64) {
 	clear.Lock()
 	defer clear.Unlock()
-	clear.ttlMap[key] = expireSeconds
+
+	delete(clear.sweeping, key)
+	if item, ok := clear.ttlMap[key]; ok {
+		item.deadline = deadline
+		heap.Fix(&clear.ttlHeap, item.index)
+		return
+	}
+	item := &ttlItem{key:key, deadline:deadline}
+	heap.Push(&clear.ttlHeap, item)
+	clear.ttlMap[key] = item
 }
 
-// 定时删除函数
-func (clear *Clear) TimingErase(storager *Storager) {
-	expiredKeyList := make([]string, 0)
+// 去掉过期时间，key永不过期
+func (clear *Clear) Unmark(key string) {
+	clear.Lock()
+	defer clear.Unlock()
+
+	delete(clear.sweeping, key)
+	if item, ok := clear.ttlMap[key]; ok {
+		heap.Remove(&clear.ttlHeap, item.index)
+		delete(clear.ttlMap, key)
+	}
+}
 
+// key的过期时间(UnixNano)，没有设置过期时间时ok返回false
+func (clear *Clear) Deadline(key string) (deadline int64, ok bool) {
 	clear.Lock()
-	// 寻找过期key，并更新过期时间
-	for key, time := range clear.ttlMap {
-		if time - IntervalDuration <= 0 {
-			expiredKeyList = append(expiredKeyList, key)
-		}
-		clear.ttlMap[key] = time - IntervalDuration
+	defer clear.Unlock()
+
+	item, ok := clear.ttlMap[key]
+	if !ok {
+		return 0, false
 	}
-	// 删除过期key
-	for _, key := range expiredKeyList {
-		delete(clear.ttlMap, key)
+	return item.deadline, true
+}
+
+// key是否已过期，没有设置过期时间的key永不过期
+func (clear *Clear) IsExpired(key string) bool {
+	clear.Lock()
+	defer clear.Unlock()
+
+	item, ok := clear.ttlMap[key]
+	return ok && time.Now().UnixNano() >= item.deadline
+}
+
+// 定时删除函数，分批取出已过期的key，逐个加锁删除，不会长时间阻塞读写
+func (clear *Clear) TimingErase(storager *Storager) {
+	now := time.Now().UnixNano()
+	for {
+		expiredKeyList := clear.popExpired(now)
+		for _, key := range expiredKeyList {
+			if clear.eraseExpired(storager, key) && clear.onExpire != nil {
+				clear.onExpire(key)
+			}
+		}
+		if len(expiredKeyList) < clear.batchSize {
+			return
+		}
 	}
-	clear.Unlock()
+}
+
+// 只持有删除一个key所需的storager锁，返回是否确实删除了数据
+// Zzkv在同一把锁内写入value并更新过期时间，取出后重新标记或去掉过期时间的key不再删除
+func (clear *Clear) eraseExpired(storager *Storager, key string) bool {
+	storager.Lock()
+	defer storager.Unlock()
 
-	// 删除所有过期kv
-	for _, key := range expiredKeyList {
-		storager.Erase(key)
+	if !clear.takeSweeping(key) {
+		return false
 	}
+	return storager.eraseLocked(key) == nil
+}
+
+// key取出后过期时间是否未被修改，同时移除取出记录
+func (clear *Clear) takeSweeping(key string) bool {
+	clear.Lock()
+	defer clear.Unlock()
 
+	ok := clear.sweeping[key]
+	delete(clear.sweeping, key)
+	return ok
+}
+
+// 从堆顶取出至多batchSize个在now之前过期的key
+func (clear *Clear) popExpired(now int64) []string {
+	clear.Lock()
+	defer clear.Unlock()
+
+	expiredKeyList := make([]string, 0)
+	for len(expiredKeyList) < clear.batchSize && clear.ttlHeap.Len() > 0 && clear.ttlHeap[0].deadline <= now {
+		item := heap.Pop(&clear.ttlHeap).(*ttlItem)
+		delete(clear.ttlMap, item.key)
+		clear.sweeping[item.key] = true
+		expiredKeyList = append(expiredKeyList, item.key)
+	}
+	return expiredKeyList
 }
 
 func (clear *Clear) Run(storager *Storager) {
-	ticker := time.NewTicker(time.Second * IntervalDuration)
+	ticker := time.NewTicker(clear.interval)
+	clear.wg.Add(1)
 	go func() {
-		for range ticker.C {
-			clear.TimingErase(storager)
+		defer clear.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				clear.TimingErase(storager)
+			case <-clear.stopChan:
+				return
+			}
 		}
 	}()
 }
 
-
+// 停止定时删除，等待正在进行的删除结束，可重复调用
+func (clear *Clear) Stop() {
+	clear.stopOnce.Do(func() {
+		close(clear.stopChan)
+	})
+	clear.wg.Wait()
+}
@@ -0,0 +1,126 @@
+package zzkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// 类型T的编解码器，编码结果由Zzkv压缩后存储
+type Codec[T any] interface {
+	Encode(val T) ([]byte, error)
+	// 数据无法解码时返回的错误可用errors.Is(err, ErrCorrupt)判断
+	Decode(data []byte) (T, error)
+}
+
+// JSON编解码，与Zzkv.Set、Zzkv.Get的格式相同，两者写入的数据可以互相读取
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(val T) ([]byte, error) {
+	return json.Marshal(val)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var result T
+	unmarshalErr := json.Unmarshal(data, &result)
+	if unmarshalErr != nil {
+		return result, fmt.Errorf("%w: %s", ErrCorrupt, unmarshalErr)
+	}
+	return result, nil
+}
+
+// gob编解码，比JSON紧凑，只能由GobCodec读取
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(val T) ([]byte, error) {
+	var buf bytes.Buffer
+	encodeErr := gob.NewEncoder(&buf).Encode(val)
+	if encodeErr != nil {
+		return nil, encodeErr
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var result T
+	decodeErr := gob.NewDecoder(bytes.NewReader(data)).Decode(&result)
+	if decodeErr != nil {
+		return result, fmt.Errorf("%w: %s", ErrCorrupt, decodeErr)
+	}
+	return result, nil
+}
+
+// 原样存储字节串
+type BytesCodec struct{}
+
+func (BytesCodec) Encode(val []byte) ([]byte, error) {
+	return val, nil
+}
+
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// 原样存储字符串
+type StringCodec struct{}
+
+func (StringCodec) Encode(val string) ([]byte, error) {
+	return []byte(val), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+// 只存放类型T的Zzkv视图，读写时不必再传指针和类型
+// 写入都是同步持久化的，与其他TypedStore共用同一个Zzkv时key不能重叠
+type TypedStore[T any] struct {
+	zzkv  *Zzkv
+	codec Codec[T]
+}
+
+// codec为nil时使用JSONCodec
+func NewTypedStore[T any](z *Zzkv, codec Codec[T]) *TypedStore[T] {
+	if codec == nil {
+		codec = JSONCodec[T]{}
+	}
+	return &TypedStore[T]{zzkv: z, codec: codec}
+}
+
+// 写入永不过期的value，之前设置的过期时间一并清除
+func (s *TypedStore[T]) Set(key string, val T) error {
+	data, encodeErr := s.codec.Encode(val)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return s.zzkv.set(key, data, true, 0)
+}
+
+// ttlTime秒后过期，见Zzkv.SetWithTTL
+func (s *TypedStore[T]) SetWithTTL(key string, val T, ttlTime int64) error {
+	data, encodeErr := s.codec.Encode(val)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	return s.zzkv.set(key, data, true, ttlDeadline(ttlTime))
+}
+
+// key不存在或已过期时返回ErrNotFound，出错时同时返回T的零值
+func (s *TypedStore[T]) Get(key string) (T, error) {
+	data, getErr := s.zzkv.get(key)
+	if getErr != nil {
+		var zero T
+		return zero, getErr
+	}
+	return s.codec.Decode(data)
+}
+
+// 同Get，出错时以error值panic，适合确定key存在的场景
+func (s *TypedStore[T]) MustGet(key string) T {
+	result, getErr := s.Get(key)
+	if getErr != nil {
+		panic(fmt.Errorf("zzkv: failed to get key %q: %w", key, getErr))
+	}
+	return result
+}
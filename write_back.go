@@ -0,0 +1,226 @@
+package zzkv
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultFlushInterval       = time.Second
+	DefaultFlushBytes    int64 = 4 << 20
+)
+
+// 回写统计
+type WriteBackStats struct {
+	Dirty       int   // 尚未持久化的key数
+	DirtyBytes  int64 // 尚未持久化的value总字节数
+	Flushed     int64 // 已回写的次数(按key计)
+	FlushErrors int64 // 回写失败的次数(按key计)
+}
+
+// 回写状态，sync为false写入的value先记为脏数据，由后台协程定期或积累到一定字节数时持久化
+// 除通知用的channel和flushMu外，所有字段都由Storager的锁保护
+type writeBack struct {
+	dirty      map[string]dirtyEntry
+	dirtyBytes int64
+	seq        uint64 // 脏数据每次变化递增，回写完成后据此判断期间是否被改写
+	flushBytes int64
+	onError    func(key string, err error)
+	stats      WriteBackStats
+	// 正在回写的key，以及回写期间被同步写入(非nil)或删除(nil)的key
+	// 回写结束后按conflicts重放，避免较旧的脏数据覆盖新值或让已删除的key复活
+	inFlight  map[string]dirtyEntry
+	conflicts map[string]*dirtyEntry
+	flushMu   sync.Mutex // 同一时刻只有一次回写
+	flushChan chan struct{}
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
+}
+
+// 尚未持久化的value及其过期时间
+type dirtyEntry struct {
+	value    []byte
+	expireAt int64
+	seq      uint64
+}
+
+func newWriteBack(opts *Options) *writeBack {
+	return &writeBack{
+		dirty:      make(map[string]dirtyEntry),
+		flushBytes: opts.FlushBytes,
+		onError:    opts.OnFlushError,
+		flushChan:  make(chan struct{}, 1),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+func (wb *writeBack) markDirty(key string, value []byte, expireAt int64) {
+	wb.clean(key)
+	wb.seq++
+	wb.dirty[key] = dirtyEntry{value: value, expireAt: expireAt, seq: wb.seq}
+	wb.dirtyBytes += int64(len(value))
+
+	// 脏数据过多时提前唤醒后台协程，已有未处理的通知则不再发送
+	if wb.flushBytes > 0 && wb.dirtyBytes >= wb.flushBytes {
+		select {
+		case wb.flushChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 去掉脏标记，返回之前是否为脏数据
+func (wb *writeBack) clean(key string) bool {
+	entry, ok := wb.dirty[key]
+	if ok {
+		wb.dirtyBytes -= int64(len(entry.value))
+		delete(wb.dirty, key)
+	}
+	return ok
+}
+
+// 修改脏数据的过期时间，不是脏数据时返回false
+func (wb *writeBack) setExpiry(key string, expireAt int64) bool {
+	entry, ok := wb.dirty[key]
+	if !ok {
+		return false
+	}
+	wb.seq++
+	entry.expireAt = expireAt
+	entry.seq = wb.seq
+	wb.dirty[key] = entry
+	return true
+}
+
+// 回写期间key被同步写入或删除，entry为nil表示删除
+func (wb *writeBack) conflict(key string, entry *dirtyEntry) {
+	if _, ok := wb.inFlight[key]; ok {
+		wb.conflicts[key] = entry
+	}
+}
+
+// 后台定期回写，直到Close
+func (s *Storager) runFlusher(interval time.Duration) {
+	wb := s.writeBack
+	ticker := time.NewTicker(interval)
+	wb.wg.Add(1)
+	go func() {
+		defer wb.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-wb.flushChan:
+			case <-wb.stopChan:
+				return
+			}
+			_ = s.Flush()
+		}
+	}()
+}
+
+// 将所有脏数据写入持久化存储，返回遇到的其中一个错误
+// 失败的key保持脏状态，下次回写时重试，并通过Options.OnFlushError通知
+func (s *Storager) Flush() error {
+	s.RLock()
+	closed := s.closed
+	s.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+
+	return s.reportFlushErrors(s.flush())
+}
+
+// 返回回写失败的key及错误
+// 持锁复制一份脏数据后释放锁再写持久化存储，期间读写不受影响
+// 写完后重新加锁，只清除期间未被改写的脏数据
+func (s *Storager) flush() map[string]error {
+	wb := s.writeBack
+	if wb == nil {
+		return nil
+	}
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+
+	s.Lock()
+	snapshot := make(map[string]dirtyEntry, len(wb.dirty))
+	for key, entry := range wb.dirty {
+		snapshot[key] = entry
+	}
+	wb.inFlight = snapshot
+	wb.conflicts = make(map[string]*dirtyEntry)
+	s.Unlock()
+
+	results := make(map[string]error, len(snapshot))
+	for key, entry := range snapshot {
+		results[key] = s.pstStorager.StorageWithExpiry(key, entry.value, entry.expireAt)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	var failures map[string]error
+	for key, entry := range snapshot {
+		storageErr := results[key]
+		if conflict, ok := wb.conflicts[key]; ok {
+			// 期间同步写入或删除的结果可能被刚写入的旧值覆盖，重放一次，极少发生
+			storageErr = s.replayConflict(key, conflict)
+		}
+		if storageErr != nil {
+			if failures == nil {
+				failures = make(map[string]error)
+			}
+			failures[key] = storageErr
+			wb.stats.FlushErrors++
+			continue
+		}
+
+		wb.stats.Flushed++
+		if current, ok := wb.dirty[key]; ok && current.seq == entry.seq {
+			wb.clean(key)
+			s.storageMap[key] = true
+		}
+	}
+	wb.inFlight = nil
+	wb.conflicts = nil
+	return failures
+}
+
+// 重新执行回写期间对key的同步写入或删除，调用方需持有锁
+func (s *Storager) replayConflict(key string, conflict *dirtyEntry) error {
+	if conflict == nil {
+		deleteErr := s.pstStorager.Delete(key)
+		if errors.Is(deleteErr, ErrNotFound) {
+			return nil
+		}
+		return deleteErr
+	}
+	return s.pstStorager.StorageWithExpiry(key, conflict.value, conflict.expireAt)
+}
+
+// 释放锁之后再回调，回调中可以调用Storager的方法
+func (s *Storager) reportFlushErrors(failures map[string]error) error {
+	var result error
+	for key, flushErr := range failures {
+		if s.writeBack.onError != nil {
+			s.writeBack.onError(key, flushErr)
+		}
+		result = flushErr
+	}
+	return result
+}
+
+// 回写统计，未开启回写时返回零值
+func (s *Storager) WriteBackStats() WriteBackStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.writeBack == nil {
+		return WriteBackStats{}
+	}
+	stats := s.writeBack.stats
+	stats.Dirty = len(s.writeBack.dirty)
+	stats.DirtyBytes = s.writeBack.dirtyBytes
+	return stats
+}
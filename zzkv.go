@@ -1,76 +1,223 @@
 package zzkv
 
+import "time"
+
 type Zzkv struct {
-	Storager
+	*Storager
 	Compression
 	*Clear
+	events 	*eventQueue
 }
 
-func New(s Storager, c Compression) Zzkv {
-	result := Zzkv{
+// 持久化存储中的过期时间读取失败时，这些key在本次运行中不会过期
+func New(s *Storager, c Compression) *Zzkv {
+	result, _ := newZzkv(s, c, NewDefaultClear(), DefaultEventQueueSize)
+	return result
+}
+
+// 总是返回可用的Zzkv，error为恢复过期时间时遇到的错误
+func newZzkv(s *Storager, c Compression, clear *Clear, eventQueueSize int) (*Zzkv, error) {
+	result := &Zzkv{
 		Storager:s,
 		Compression: c,
+		Clear:clear,
+		events:newEventQueue(eventQueueSize),
 	}
 
 	if c == nil {
 		result.Compression = NewDefaultCompression()
 	}
-	return result
+	// 过期删除和缓存淘汰都通过事件队列异步通知
+	result.Clear.onExpire = func(key string) {
+		result.events.publish(eventExpire, key)
+	}
+	if notifier, ok := s.cacheStorager.(interface{ SetEvictHandler(func(key string)) }); ok {
+		notifier.SetEvictHandler(func(key string) {
+			result.events.publish(eventEvict, key)
+		})
+	}
+	// 重启前设置的过期时间随数据持久化，重新交给清除器
+	expiries, expiriesErr := s.Expiries()
+	for key, deadline := range expiries {
+		result.Clear.MarkAt(key, deadline)
+	}
+	// 启动TTL清除器
+	result.Clear.Run(result.Storager)
+	return result, expiriesErr
 }
 
-func NewDefault() *Zzkv {
-	z := &Zzkv{
-		Storager:Storager{
-			pstStorager:NewDefaultPstStorager(),
-			cacheStorager:NewDefaultCacheStorager(),
-			storageMap:make(map[string]bool),
-		},
-		Compression:NewDefaultCompression(),
-		Clear:NewDefaultClear(),
+// 按配置创建，opts为nil时使用默认配置
+func NewDefault(opts *Options) (*Zzkv, error) {
+	opts = opts.normalize()
+	storager, storagerErr := NewDefaultStorager(opts)
+	if storagerErr != nil {
+		return nil, storagerErr
 	}
-	// 启动TTL清除器
-	z.Clear.Run(&z.Storager)
-	return z
+
+	result, newErr := newZzkv(storager, NewDefaultCompression(), NewClear(opts.ClearInterval, opts.ClearBatchSize), opts.EventQueueSize)
+	if newErr != nil {
+		_ = result.Close()
+		return nil, newErr
+	}
+	return result, nil
+}
+
+// 停止TTL清除器，写完未回写的数据后关闭持久化存储，之后的调用返回ErrClosed
+// 返回前交付完已入队的事件
+func (z *Zzkv) Close() error {
+	z.Clear.Stop()
+	closeErr := z.Storager.Close()
+	z.events.stop()
+	return closeErr
+}
+
+// 注册过期回调，后台清理删除过期key后调用，读取时发现过期不会触发，停机期间过期的key也不会触发
+// 回调在单独的协程中按顺序执行，事件队列满时丢弃，见EventStats
+func (z *Zzkv) OnExpire(fn func(key string)) {
+	z.events.subscribe(eventExpire, fn)
+}
+
+// 注册淘汰回调，缓存因超出容量淘汰key后调用，key仍可从持久化存储读到，执行方式同OnExpire
+func (z *Zzkv) OnEvict(fn func(key string)) {
+	z.events.subscribe(eventEvict, fn)
+}
+
+// 事件统计
+func (z *Zzkv) EventStats() EventStats {
+	return z.events.eventStats()
 }
 
+// 写入永不过期的value，之前SetWithTTL设置的过期时间一并清除
 func (z *Zzkv) Set(key string, val interface{}, sync bool) error {
 	// 序列化对象
 	data, err := Serialize(val)
 	if err != nil {
 		return err
 	}
+
+	return z.set(key, data, sync, 0)
+}
+
+// ttlTime秒后过期，过期时间随value一起持久化，重启后依然有效
+func (z *Zzkv) SetWithTTL(key string, val interface{}, sync bool, ttlTime int64) error {
+	// 序列化对象
+	data, err := Serialize(val)
+	if err != nil {
+		return err
+	}
+
+	return z.set(key, data, sync, ttlDeadline(ttlTime))
+}
+
+// ttlTime秒之后的UnixNano
+func ttlDeadline(ttlTime int64) int64 {
+	return time.Now().Add(time.Duration(ttlTime) * time.Second).UnixNano()
+}
+
+// 压缩并存储序列化后的数据，expireAt为过期时间的UnixNano，0表示永不过期并清除之前的过期时间
+// 写入value和更新过期时间在同一次Storager加锁内完成，后台清理不会看到新value配旧过期时间
+func (z *Zzkv) set(key string, data []byte, sync bool, expireAt int64) error {
 	// 压缩数据
-	data = z.Compress(data)
+	data, err := z.Compress(data)
+	if err != nil {
+		return err
+	}
 
+	z.Storager.Lock()
+	defer z.Storager.Unlock()
 	//存储数据
-	setErr := z.Storager.Set(key, data, sync)
+	setErr := z.Storager.setWithExpiryLocked(key, data, sync, expireAt)
 	if setErr != nil {
 		return setErr
 	}
 
+	if expireAt == 0 {
+		z.Clear.Unmark(key)
+	} else {
+		z.Clear.MarkAt(key, expireAt)
+	}
 	return nil
 }
 
-func (z *Zzkv) SetWithTTL(key string, val interface{}, sync bool, ttlTime int64) error {
-	setErr := z.Set(key, val, sync)
-	if setErr != nil {
-		return setErr
+// 剩余的存活时间，没有设置过期时间时返回NoTTL，key不存在或已过期时返回ErrNotFound
+func (z *Zzkv) TTL(key string) (time.Duration, error) {
+	exists, existsErr := z.Exists(key)
+	if existsErr != nil {
+		return 0, existsErr
+	}
+	if !exists {
+		return 0, ErrNotFound
+	}
+
+	deadline, ok := z.Clear.Deadline(key)
+	if !ok {
+		return NoTTL, nil
+	}
+	ttl := time.Duration(deadline - time.Now().UnixNano())
+	if ttl <= 0 {
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+// 设置为ttlTime秒后过期，覆盖之前的过期时间
+func (z *Zzkv) Expire(key string, ttlTime int64) error {
+	return z.ExpireAt(key, time.Now().Add(time.Duration(ttlTime)*time.Second))
+}
+
+// 设置为在expireAt时刻过期，覆盖之前的过期时间，key不存在或已过期时返回ErrNotFound
+func (z *Zzkv) ExpireAt(key string, expireAt time.Time) error {
+	z.Storager.Lock()
+	defer z.Storager.Unlock()
+	if z.Clear.IsExpired(key) {
+		return ErrNotFound
+	}
+
+	// key不存在时返回ErrNotFound
+	deadline := expireAt.UnixNano()
+	expiryErr := z.Storager.setExpiryLocked(key, deadline)
+	if expiryErr != nil {
+		return expiryErr
 	}
 
-	z.Clear.Mark(key, ttlTime)
+	z.Clear.MarkAt(key, deadline)
 	return nil
 }
 
-func (z *Zzkv) Get(key string, val interface{}) error {
-	// 获取数据
-	data := z.Storager.Get(key)
+// 去掉过期时间，key永不过期，key不存在或已过期时返回ErrNotFound
+func (z *Zzkv) Persist(key string) error {
+	z.Storager.Lock()
+	defer z.Storager.Unlock()
+	if z.Clear.IsExpired(key) {
+		return ErrNotFound
+	}
 
-	// 解压数据
-	data  = z.Decompress(data)
+	expiryErr := z.Storager.setExpiryLocked(key, 0)
+	if expiryErr != nil {
+		return expiryErr
+	}
 
-	// 反序列对象
-	err := Deserialize(data, val)
+	z.Clear.Unmark(key)
+	return nil
+}
+
+// key是否存在且未过期
+func (z *Zzkv) Exists(key string) (bool, error) {
+	if z.Clear.IsExpired(key) {
+		return false, nil
+	}
+	return z.Storager.Exists(key)
+}
+
+// 已过期的key返回ErrNotFound
+func (z *Zzkv) Get(key string, val interface{}) error {
+	data, err := z.get(key)
+	if err != nil {
+		return err
+	}
 
+	// 反序列对象
+	err = Deserialize(data, val)
 	if err != nil {
 		return err
 	}
@@ -78,6 +225,22 @@ func (z *Zzkv) Get(key string, val interface{}) error {
 	return nil
 }
 
+// 读取并解压，返回序列化后的数据
+func (z *Zzkv) get(key string) ([]byte, error) {
+	if z.Clear.IsExpired(key) {
+		return nil, ErrNotFound
+	}
+
+	// 获取数据
+	data, err := z.Storager.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解压数据
+	return z.Decompress(data)
+}
+
 
 
 